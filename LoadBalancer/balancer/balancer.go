@@ -0,0 +1,116 @@
+// Package balancer proxies Ethereum JSON-RPC requests to the best node of
+// pools of nodes it keeps observing. It is the core of the LoadBalancer
+// command and can be embedded into other Go programs:
+//
+//	config, err := balancer.LoadConfig([]string{"config.yml"})
+//	...
+//	b, err := balancer.New(config)
+//	...
+//	defer b.Close()
+//	http.ListenAndServe(":8080", b)
+package balancer
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// Balancer is an http.Handler serving JSON-RPC requests from the current node
+// of the pool they are routed to, along with the /info, /metrics, /events,
+// /history, /usage, /ready and /dashboard endpoints
+type Balancer struct {
+	pools   []*Pool
+	handler http.Handler
+	cancel  context.CancelFunc
+	// observers are the periodic observe loops of the pools
+	observers sync.WaitGroup
+	// shared by the pools of this balancer only
+	*shared
+}
+
+// New validates the config, applying the defaults, and starts observing the
+// nodes of its pools. It returns once every pool was observed once, so
+// requests can be served right away. Close stops the observers.
+func New(config Config) (*Balancer, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	b := &Balancer{shared: &shared{events: NewEventHub(), metrics: newMetrics()}}
+	if config.History.Path != "" {
+		store, err := OpenHistory(config.History)
+		if err != nil {
+			return nil, err
+		}
+		b.history = store
+	}
+
+	if config.Usage.Enabled {
+		b.usage = newUsageStore(config.Usage, b.metrics)
+	}
+
+	b.tracer = NewTracer(config.Tracing)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancel = cancel
+
+	for _, poolConfig := range config.AllPools() {
+		pool, err := newPool(poolConfig, b.shared)
+		if err != nil {
+			cancel()
+			b.tracer.Close()
+			if b.history != nil {
+				b.history.Close()
+			}
+			return nil, err
+		}
+		b.pools = append(b.pools, pool)
+	}
+
+	for _, pool := range b.pools {
+		pool.observe(ctx)
+
+		b.observers.Add(1)
+		go func(pool *Pool) {
+			defer b.observers.Done()
+			pool.startPeriodicObserve(ctx)
+		}(pool)
+	}
+
+	b.handler = newHandler(ctx, b.shared, b.pools)
+
+	return b, nil
+}
+
+func (b *Balancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.handler.ServeHTTP(w, r)
+}
+
+// Pools returns the pools of the balancer, the default pool first
+func (b *Balancer) Pools() []*Pool {
+	return b.pools
+}
+
+// Close stops observing the nodes, ends the /events streams and flushes the
+// spans not exported yet. Requests still in flight are served by the last
+// current nodes.
+func (b *Balancer) Close() error {
+	b.cancel()
+	b.observers.Wait()
+
+	for _, pool := range b.pools {
+		for i := range pool.Nodes {
+			pool.Nodes[i].transport.CloseIdleConnections()
+		}
+		pool.shadow.closeIdleConnections()
+	}
+
+	b.tracer.Close()
+
+	if b.history != nil {
+		return b.history.Close()
+	}
+
+	return nil
+}
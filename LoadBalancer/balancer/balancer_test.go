@@ -0,0 +1,185 @@
+package balancer
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	a, b := newFakeNode(100), newFakeNode(105)
+	defer a.Close()
+	defer b.Close()
+
+	config := Config{PoolConfig: PoolConfig{
+		Interval: 1,
+		Nodes:    []NodeConfig{{Url: a.server.URL}, {Url: b.server.URL}},
+	}}
+
+	lb, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lb.Close()
+
+	server := httptest.NewServer(lb)
+	defer server.Close()
+
+	status, reply := postRPC(t, server.URL, getBalance)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	if url := servedBy(t, reply); url != b.server.URL {
+		t.Errorf("served by %s, want %s", url, b.server.URL)
+	}
+
+	if pools := lb.Pools(); len(pools) != 1 || pools[0].Config.Name != defaultPoolName {
+		t.Errorf("pools = %v, want the default pool", pools)
+	}
+
+	resp, err := http.Get(server.URL + "/info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("/info status = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestBalancersAreIndependent(t *testing.T) {
+	node := newFakeNode(100)
+	defer node.Close()
+
+	newBalancer := func(usage bool) (*Balancer, *httptest.Server) {
+		config := Config{PoolConfig: PoolConfig{Interval: 1, Nodes: []NodeConfig{{Url: node.server.URL}}}}
+		config.Usage.Enabled = usage
+
+		lb, err := New(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lb, httptest.NewServer(lb)
+	}
+
+	a, serverA := newBalancer(true)
+	defer a.Close()
+	defer serverA.Close()
+	b, serverB := newBalancer(false)
+	defer b.Close()
+	defer serverB.Close()
+
+	postRPC(t, serverA.URL, getBalance)
+	postRPC(t, serverB.URL, getBalance)
+	postRPC(t, serverB.URL, getBalance)
+
+	if rows := a.usage.Query(time.Unix(0, 0), "", ""); len(rows) != 1 || rows[0].Requests != 1 {
+		t.Errorf("usage of a = %+v, want its one request", rows)
+	}
+
+	resp, err := http.Get(serverB.URL + "/usage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("/usage of b status = %d, want 404", resp.StatusCode)
+	}
+
+	if a.events == b.events {
+		t.Error("balancers share their event hub")
+	}
+
+	// only a accounts usage, so only its /metrics exposes it
+	for _, c := range []struct {
+		url   string
+		usage bool
+	}{{serverA.URL, true}, {serverB.URL, false}} {
+		resp, err := http.Get(c.url + "/metrics")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exposed := strings.Contains(string(body), "loadbalancer_client_requests_total{"); exposed != c.usage {
+			t.Errorf("usage exposed on %s/metrics %v, want %v", c.url, exposed, c.usage)
+		}
+	}
+}
+
+func TestNewInvalidConfig(t *testing.T) {
+	config := Config{PoolConfig: PoolConfig{Nodes: []NodeConfig{{Url: "ftp://node"}}}}
+
+	if _, err := New(config); err == nil {
+		t.Error("New accepted a node without http(s) URL and check interval")
+	}
+}
+
+func TestNewPoolInvalidUrl(t *testing.T) {
+	pool, err := NewPool(PoolConfig{Name: "broken", Nodes: []NodeConfig{{Url: "https://user:secret@[::1"}}})
+	if pool != nil || err == nil {
+		t.Fatalf("pool %v, error %v, want an error", pool, err)
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("error %v shows the credentials", err)
+	}
+}
+
+func TestCloseClosesShadowConnections(t *testing.T) {
+	node := newFakeNode(100)
+	defer node.Close()
+
+	var open int64
+	shadow := httptest.NewUnstartedServer(http.HandlerFunc(node.serveHTTP))
+	shadow.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			atomic.AddInt64(&open, 1)
+		case http.StateClosed, http.StateHijacked:
+			atomic.AddInt64(&open, -1)
+		}
+	}
+	shadow.Start()
+	defer shadow.Close()
+
+	lb, err := New(Config{PoolConfig: PoolConfig{
+		Interval: 60,
+		Nodes:    []NodeConfig{{Url: node.server.URL}},
+		Shadow:   ShadowConfig{Url: shadow.URL, Percentage: 100},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(lb)
+	defer server.Close()
+
+	postRPC(t, server.URL, getBalance)
+	waitFor(t, "the shadow comparison", func() bool {
+		return metricValue(lb.metrics.shadowRequests, defaultPoolName, "eth_getBalance") > 0
+	})
+	if connections := atomic.LoadInt64(&open); connections != 1 {
+		t.Fatalf("%d connections to the shadow node, want 1", connections)
+	}
+
+	lb.Close()
+	waitFor(t, "the shadow connection closed", func() bool { return atomic.LoadInt64(&open) == 0 })
+}
+
+// waitFor polls the condition for up to two seconds
+func waitFor(t *testing.T, what string, condition func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,149 @@
+package balancer
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+const (
+	CircuitClosed   = "closed"
+	CircuitHalfOpen = "half-open"
+	CircuitOpen     = "open"
+)
+
+var circuitStateValues = map[string]float64{
+	CircuitClosed:   0,
+	CircuitHalfOpen: 1,
+	CircuitOpen:     2,
+}
+
+// CircuitBreaker tracks the outcome of the last probes and proxied requests of
+// a node. It opens once the failure ratio over the window is exceeded, keeps
+// the node out of selection for the cool-down period and then lets a number of
+// trial requests through before closing again.
+type CircuitBreaker struct {
+	name    string
+	config  CircuitBreakerConfig
+	metrics *metrics
+
+	mu       sync.Mutex
+	state    string
+	results  []bool
+	next     int
+	openedAt time.Time
+	trials   int
+}
+
+// NewCircuitBreaker returns a closed circuit breaker exposing its state to
+// metrics of its own
+func NewCircuitBreaker(name string, config CircuitBreakerConfig) *CircuitBreaker {
+	return newCircuitBreaker(name, config, newMetrics())
+}
+
+func newCircuitBreaker(name string, config CircuitBreakerConfig, m *metrics) *CircuitBreaker {
+	b := &CircuitBreaker{
+		name:    name,
+		config:  config,
+		metrics: m,
+		state:   CircuitClosed,
+		results: make([]bool, 0, config.Window),
+	}
+	m.circuitState.Set(circuitStateValues[b.state], name)
+
+	return b
+}
+
+func (b *CircuitBreaker) enabled() bool {
+	return b.config.FailureRatio > 0
+}
+
+// State returns the current state, moving an open circuit to half-open once
+// its cool-down has passed
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.coolDown()
+
+	return b.state
+}
+
+func (b *CircuitBreaker) coolDown() {
+	if b.state == CircuitOpen && time.Since(b.openedAt) >= time.Duration(b.config.CoolDown)*time.Second {
+		b.setState(CircuitHalfOpen)
+	}
+}
+
+func (b *CircuitBreaker) Record(success bool) {
+	if !b.enabled() {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.coolDown()
+
+	switch b.state {
+	case CircuitOpen:
+		return
+	case CircuitHalfOpen:
+		if !success {
+			b.open()
+			return
+		}
+
+		b.trials++
+		if b.trials >= b.config.TrialRequests {
+			b.results = b.results[:0]
+			b.next = 0
+			b.setState(CircuitClosed)
+		}
+		return
+	}
+
+	if len(b.results) < b.config.Window {
+		b.results = append(b.results, success)
+	} else {
+		b.results[b.next] = success
+		b.next = (b.next + 1) % b.config.Window
+	}
+
+	if len(b.results) < b.config.MinRequests {
+		return
+	}
+
+	failures := 0
+	for _, ok := range b.results {
+		if !ok {
+			failures++
+		}
+	}
+
+	if float64(failures)/float64(len(b.results)) >= b.config.FailureRatio {
+		b.open()
+	}
+}
+
+func (b *CircuitBreaker) open() {
+	b.openedAt = time.Now()
+	b.trials = 0
+	b.setState(CircuitOpen)
+	b.metrics.circuitOpened.Inc(b.name)
+}
+
+func (b *CircuitBreaker) setState(state string) {
+	if b.state != state && state == CircuitClosed {
+		Info.Printf("Circuit of node %s is %s", b.name, state)
+	} else if b.state != state {
+		Warning.Printf("Circuit of node %s is %s", b.name, state)
+	}
+
+	b.state = state
+	b.metrics.circuitState.Set(circuitStateValues[state], b.name)
+}
+
+func (b *CircuitBreaker) MarshalJSON() ([]byte, error) {
+	return json.Marshal(b.State())
+}
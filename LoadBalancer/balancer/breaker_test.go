@@ -0,0 +1,102 @@
+package balancer
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	b := NewCircuitBreaker("test", CircuitBreakerConfig{FailureRatio: 0.5, Window: 4, MinRequests: 4, CoolDown: 60, TrialRequests: 2})
+
+	for _, success := range []bool{true, false, true} {
+		b.Record(success)
+	}
+	if state := b.State(); state != CircuitClosed {
+		t.Fatalf("state = %s before min_requests, want closed", state)
+	}
+
+	b.Record(false)
+	if state := b.State(); state != CircuitOpen {
+		t.Fatalf("state = %s at the failure ratio, want open", state)
+	}
+
+	// results are ignored while open
+	b.Record(true)
+	if state := b.State(); state != CircuitOpen {
+		t.Errorf("state = %s after a success while open, want open", state)
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	b := NewCircuitBreaker("test", CircuitBreakerConfig{FailureRatio: 0.5, Window: 2, MinRequests: 1, TrialRequests: 2})
+
+	b.Record(false)
+	if state := b.State(); state != CircuitHalfOpen {
+		t.Fatalf("state = %s without cool-down, want half-open", state)
+	}
+
+	b.Record(false)
+	b.Record(true)
+	b.Record(true)
+	if state := b.State(); state != CircuitClosed {
+		t.Errorf("state = %s after the trial requests, want closed", state)
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	b := NewCircuitBreaker("test", CircuitBreakerConfig{Window: 2, MinRequests: 1})
+
+	b.Record(false)
+	b.Record(false)
+	if state := b.State(); state != CircuitClosed {
+		t.Errorf("state = %s without failure_ratio, want closed", state)
+	}
+}
+
+func TestCircuitBreakerIgnoresTimeouts(t *testing.T) {
+	a := newFakeNode(100)
+	defer a.Close()
+
+	pool := newTestPool(t, []*fakeNode{a}, func(c *PoolConfig) {
+		c.CircuitBreaker = CircuitBreakerConfig{FailureRatio: 0.5, Window: 4, MinRequests: 2}
+		c.Limits.MethodTimeouts = map[string]int{"eth_getBalance": 1}
+	})
+	observeTest(pool)
+
+	proxy := newTestProxy(pool)
+	defer proxy.Close()
+
+	a.setLatency(1200 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if status, _ := postRPC(t, proxy.URL, getBalance); status != http.StatusGatewayTimeout {
+			t.Fatalf("status = %d, want 504", status)
+		}
+	}
+
+	if state := pool.Nodes[0].Circuit.State(); state != CircuitClosed {
+		t.Errorf("state = %s after method timeouts, want closed", state)
+	}
+}
+
+func TestCircuitBreakerOpensOnServerErrors(t *testing.T) {
+	a := newFakeNode(100)
+	defer a.Close()
+
+	pool := newTestPool(t, []*fakeNode{a}, func(c *PoolConfig) {
+		c.CircuitBreaker = CircuitBreakerConfig{FailureRatio: 0.5, Window: 4, MinRequests: 2}
+	})
+	observeTest(pool)
+
+	proxy := newTestProxy(pool)
+	defer proxy.Close()
+
+	a.setStatus(http.StatusInternalServerError)
+	for i := 0; i < 2; i++ {
+		postRPC(t, proxy.URL, getBalance)
+	}
+
+	if state := pool.Nodes[0].Circuit.State(); state != CircuitOpen {
+		t.Errorf("state = %s after server errors, want open", state)
+	}
+}
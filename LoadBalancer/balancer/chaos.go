@@ -0,0 +1,187 @@
+package balancer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+const (
+	ChaosLatency = "latency"
+	ChaosError   = "error"
+	ChaosDrop    = "drop"
+	ChaosStale   = "stale"
+
+	defaultChaosDelay  = 1000
+	defaultChaosStatus = http.StatusServiceUnavailable
+	defaultChaosBlocks = 10
+
+	chaosErrorMessage = "Injected fault"
+)
+
+// Chaos injects faults into a share of the proxied requests of a pool, so
+// clients can be tested against misbehaving nodes
+type Chaos struct {
+	pool    string
+	faults  []ChaosFault
+	metrics *metrics
+}
+
+func newChaos(config PoolConfig, m *metrics) *Chaos {
+	if !config.Chaos.Enabled || len(config.Chaos.Faults) == 0 {
+		return nil
+	}
+
+	Warning.Printf("Chaos mode is enabled for pool %s, faults are injected into proxied requests", config.Name)
+
+	return &Chaos{pool: config.Name, faults: config.Chaos.Faults, metrics: m}
+}
+
+func (f ChaosFault) matches(methods []string) bool {
+	if len(f.Methods) == 0 {
+		return true
+	}
+
+	for _, method := range methods {
+		for _, m := range f.Methods {
+			if method == m {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// pick returns the fault to inject into a request calling the methods, if
+// any. Faults are tried in the configured order.
+func (c *Chaos) pick(methods []string) *ChaosFault {
+	if c == nil {
+		return nil
+	}
+
+	for i, f := range c.faults {
+		if f.matches(methods) && rand.Float64()*100 < f.Percentage {
+			return &c.faults[i]
+		}
+	}
+
+	return nil
+}
+
+// inject applies the fault to a request before it is proxied. It returns false
+// when the fault answered the request, otherwise the request goes on with
+// the returned body.
+func (c *Chaos) inject(ctx context.Context, w http.ResponseWriter, r *http.Request, fault *ChaosFault, body []byte, head int64) ([]byte, bool) {
+	c.metrics.chaosFaults.Inc(c.pool, fault.Type)
+
+	switch fault.Type {
+	case ChaosLatency:
+		select {
+		case <-time.After(time.Duration(fault.Delay) * time.Millisecond):
+		case <-ctx.Done():
+			// cut short by the method timeout, answered like an upstream timeout
+			proxyErrorHandler(w, r.WithContext(context.WithValue(ctx, requestBodyKey{}, body)), ctx.Err())
+			return body, false
+		}
+	case ChaosError:
+		if fault.Code != 0 {
+			writeRPCError(w, http.StatusOK, body, fault.Code, chaosErrorMessage)
+		} else {
+			http.Error(w, chaosErrorMessage, fault.Status)
+		}
+		return body, false
+	case ChaosDrop:
+		// closes the connection without a response
+		panic(http.ErrAbortHandler)
+	case ChaosStale:
+		block := head - fault.Blocks
+		if block < 0 {
+			block = 0
+		}
+
+		var call rpcCall
+		if err := json.Unmarshal(body, &call); err == nil && call.Method == "eth_blockNumber" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": call.Id, "result": fmt.Sprintf("0x%x", block)})
+			return body, false
+		}
+
+		body = staleBody(body, block)
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+	}
+
+	return body, true
+}
+
+// staleBody replaces the latest and pending block tags in the parameters of a
+// single or batch request by block, like a node lagging behind would read
+func staleBody(body []byte, block int64) []byte {
+	tag, _ := json.Marshal(fmt.Sprintf("0x%x", block))
+
+	rewrite := func(param json.RawMessage) json.RawMessage {
+		var s string
+		if json.Unmarshal(param, &s) == nil {
+			if s == "latest" || s == "pending" {
+				return tag
+			}
+			return param
+		}
+
+		var filter map[string]json.RawMessage
+		if json.Unmarshal(param, &filter) != nil {
+			return param
+		}
+		changed := false
+		for _, key := range []string{"fromBlock", "toBlock"} {
+			if v, ok := filter[key]; ok && json.Unmarshal(v, &s) == nil && (s == "latest" || s == "pending") {
+				filter[key] = tag
+				changed = true
+			}
+		}
+		if !changed {
+			return param
+		}
+		rewritten, _ := json.Marshal(filter)
+		return rewritten
+	}
+
+	rewriteCall := func(call map[string]json.RawMessage) {
+		var params []json.RawMessage
+		if json.Unmarshal(call["params"], &params) != nil {
+			return
+		}
+		for i, param := range params {
+			params[i] = rewrite(param)
+		}
+		call["params"], _ = json.Marshal(params)
+	}
+
+	var batch []map[string]json.RawMessage
+	if err := json.Unmarshal(body, &batch); err == nil {
+		for _, call := range batch {
+			rewriteCall(call)
+		}
+		if rewritten, err := json.Marshal(batch); err == nil {
+			return rewritten
+		}
+		return body
+	}
+
+	var call map[string]json.RawMessage
+	if err := json.Unmarshal(body, &call); err != nil {
+		return body
+	}
+	rewriteCall(call)
+	if rewritten, err := json.Marshal(call); err == nil {
+		return rewritten
+	}
+
+	return body
+}
@@ -0,0 +1,145 @@
+package balancer
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// newChaosProxy serves a pool of the node injecting the faults and returns its
+// URL
+func newChaosProxy(t *testing.T, node *fakeNode, faults ...ChaosFault) (string, func()) {
+	pool := newTestPool(t, []*fakeNode{node}, func(c *PoolConfig) {
+		c.Chaos = ChaosConfig{Enabled: true, Faults: faults}
+	})
+	observeTest(pool)
+
+	proxy := newTestProxy(pool)
+
+	return proxy.URL, proxy.Close
+}
+
+func TestChaosDisabled(t *testing.T) {
+	config := PoolConfig{Chaos: ChaosConfig{Faults: []ChaosFault{{Type: ChaosDrop, Percentage: 100}}}}
+	if chaos := newChaos(config, newMetrics()); chaos != nil {
+		t.Error("faults injected without chaos.enabled")
+	}
+}
+
+func TestChaosError(t *testing.T) {
+	a := newFakeNode(100)
+	defer a.Close()
+
+	url, stop := newChaosProxy(t, a,
+		ChaosFault{Type: ChaosError, Methods: []string{"eth_getBalance"}, Percentage: 100, Code: -32005})
+	defer stop()
+
+	status, reply := postRPC(t, url, getBalance)
+	if status != http.StatusOK || reply.Error == nil || reply.Error.Code != -32005 || reply.Id != 1 {
+		t.Errorf("status %d, reply %+v, want a JSON-RPC error -32005", status, reply)
+	}
+
+	status, _ = postRPC(t, url, `{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]}`)
+	if status != http.StatusOK {
+		t.Errorf("status of another method = %d, want 200", status)
+	}
+	if calls := a.calls("eth_getBalance"); calls != 0 {
+		t.Errorf("failed request proxied %d times", calls)
+	}
+}
+
+func TestChaosHTTPError(t *testing.T) {
+	a := newFakeNode(100)
+	defer a.Close()
+
+	url, stop := newChaosProxy(t, a, ChaosFault{Type: ChaosError, Percentage: 100})
+	defer stop()
+
+	if status, _ := postRPC(t, url, getBalance); status != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503", status)
+	}
+}
+
+func TestChaosLatency(t *testing.T) {
+	a := newFakeNode(100)
+	defer a.Close()
+
+	url, stop := newChaosProxy(t, a, ChaosFault{Type: ChaosLatency, Percentage: 100, Delay: 200})
+	defer stop()
+
+	start := time.Now()
+	status, _ := postRPC(t, url, getBalance)
+	if status != http.StatusOK {
+		t.Errorf("status = %d, want 200", status)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("answered after %v, want at least 200ms", elapsed)
+	}
+}
+
+func TestChaosLatencyTimeout(t *testing.T) {
+	a := newFakeNode(100)
+	defer a.Close()
+
+	pool := newTestPool(t, []*fakeNode{a}, func(c *PoolConfig) {
+		c.Chaos = ChaosConfig{Enabled: true, Faults: []ChaosFault{{Type: ChaosLatency, Percentage: 100, Delay: 2000}}}
+		c.Limits.MethodTimeouts = map[string]int{"eth_getBalance": 1}
+	})
+	observeTest(pool)
+
+	proxy := newTestProxy(pool)
+	defer proxy.Close()
+
+	status, reply := postRPC(t, proxy.URL, getBalance)
+	if status != http.StatusGatewayTimeout || reply.Error == nil || reply.Id != 1 {
+		t.Errorf("status %d, reply %+v, want a 504 JSON-RPC error", status, reply)
+	}
+}
+
+func TestChaosDrop(t *testing.T) {
+	a := newFakeNode(100)
+	defer a.Close()
+
+	url, stop := newChaosProxy(t, a, ChaosFault{Type: ChaosDrop, Percentage: 100})
+	defer stop()
+
+	resp, err := http.Post(url, "application/json", bytes.NewBufferString(getBalance))
+	if err == nil {
+		resp.Body.Close()
+		t.Errorf("status %d, want the connection dropped", resp.StatusCode)
+	}
+}
+
+func TestChaosStale(t *testing.T) {
+	a := newFakeNode(100)
+	defer a.Close()
+
+	url, stop := newChaosProxy(t, a, ChaosFault{Type: ChaosStale, Percentage: 100, Blocks: 7})
+	defer stop()
+
+	_, reply := postRPC(t, url, `{"jsonrpc":"2.0","id":3,"method":"eth_blockNumber","params":[]}`)
+	if string(reply.Result) != `"0x5d"` || reply.Id != 3 {
+		t.Errorf("eth_blockNumber reply %+v, want block 93", reply)
+	}
+
+	_, reply = postRPC(t, url, `{"jsonrpc":"2.0","id":4,"method":"eth_getBlockByNumber","params":["latest",false]}`)
+	var block rpcBlock
+	if err := json.Unmarshal(reply.Result, &block); err != nil || block.Number != "0x5d" {
+		t.Errorf("eth_getBlockByNumber reply %s, want block 93", reply.Result)
+	}
+}
+
+func TestStaleBody(t *testing.T) {
+	for body, want := range map[string]string{
+		`{"id":1,"method":"eth_call","params":[{"to":"0x1"},"latest"]}`:                        `{"id":1,"method":"eth_call","params":[{"to":"0x1"},"0xa"]}`,
+		`[{"id":1,"method":"eth_getLogs","params":[{"fromBlock":"0x1","toBlock":"pending"}]}]`: `[{"id":1,"method":"eth_getLogs","params":[{"fromBlock":"0x1","toBlock":"0xa"}]}]`,
+		`{"id":1,"method":"eth_getBalance","params":["0x0","0x5"]}`:                            `{"id":1,"method":"eth_getBalance","params":["0x0","0x5"]}`,
+		`not json`: `not json`,
+	} {
+		if got := string(staleBody([]byte(body), 10)); got != want {
+			t.Errorf("staleBody(%s) = %s, want %s", body, got, want)
+		}
+	}
+}
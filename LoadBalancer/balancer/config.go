@@ -0,0 +1,694 @@
+package balancer
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net/url"
+	"strings"
+)
+
+const (
+	SelectionBlock   = "block"
+	SelectionLatency = "latency"
+
+	defaultPoolName = "default"
+
+	defaultLatencyAlpha = 0.3
+
+	defaultConnectionTimeout = 5
+	defaultProbeWorkers      = 8
+
+	defaultHistoryRetentionDays = 30
+
+	defaultBreakerWindow        = 10
+	defaultBreakerMinRequests   = 5
+	defaultBreakerCoolDown      = 30
+	defaultBreakerTrialRequests = 3
+)
+
+type Config struct {
+	Port       int `yaml:"port"`
+	PoolConfig `yaml:",inline"`
+	Pools      []PoolConfig  `yaml:"pools"`
+	History    HistoryConfig `yaml:"history"`
+	Usage      UsageConfig   `yaml:"usage"`
+	Tracing    TracingConfig `yaml:"tracing"`
+}
+
+// UsageConfig enables the accounting of the calls of every client, identified
+// by the API key in Header, named by Clients, or by IP address. Calls cost
+// their method's weight in compute units, DefaultWeight if not listed.
+// Usage is counted per Interval seconds and kept for RetentionHours. Clients
+// beyond the MaxClients unnamed ones of an interval are counted as other.
+type UsageConfig struct {
+	Enabled           bool               `yaml:"enabled"`
+	Header            string             `yaml:"header"`
+	Clients           map[string]string  `yaml:"clients"`
+	TrustForwardedFor bool               `yaml:"trust_forwarded_for"`
+	Weights           map[string]float64 `yaml:"weights"`
+	DefaultWeight     float64            `yaml:"default_weight"`
+	Interval          int                `yaml:"interval"`
+	RetentionHours    int                `yaml:"retention_hours"`
+	MaxClients        int                `yaml:"max_clients"`
+}
+
+// HistoryConfig enables the persistent node history when Path is set
+type HistoryConfig struct {
+	Path          string `yaml:"path"`
+	RetentionDays int    `yaml:"retention_days"`
+}
+
+// PoolConfig describes an independent set of nodes of one chain. The top level
+// settings form the default pool, which serves every request not routed to
+// one of the pools, and are inherited by the pools unless overridden.
+type PoolConfig struct {
+	Name           string       `yaml:"name"`
+	PathPrefix     string       `yaml:"path_prefix"`
+	Host           string       `yaml:"host"`
+	ChainId        int64        `yaml:"chain_id"`
+	Nodes          []NodeConfig `yaml:"nodes"`
+	Interval       int          `yaml:"check_interval"`
+	MaxHeadAge     int          `yaml:"max_head_age"`
+	BlockThreshold int64        `yaml:"block_threshold"`
+	// BlockTreshold is the original, misspelled key of BlockThreshold
+	BlockTreshold     int64                `yaml:"block_treshold"`
+	ConnectionTimeout int                  `yaml:"connection_timeout"`
+	ProbeWorkers      int                  `yaml:"probe_workers"`
+	Selection         string               `yaml:"selection"`
+	LatencyTolerance  int64                `yaml:"latency_tolerance"`
+	LatencyAlpha      float64              `yaml:"latency_alpha"`
+	CircuitBreaker    CircuitBreakerConfig `yaml:"circuit_breaker"`
+	Shadow            ShadowConfig         `yaml:"shadow"`
+	Quorum            QuorumConfig         `yaml:"quorum"`
+	Limits            LimitsConfig         `yaml:"limits"`
+	GetLogs           GetLogsConfig        `yaml:"get_logs"`
+	Transport         TransportConfig      `yaml:"transport"`
+	Chaos             ChaosConfig          `yaml:"chaos"`
+	Finality          FinalityConfig       `yaml:"finality"`
+}
+
+// FinalityConfig probes the finalized and safe heads of post-merge nodes. With
+// MaxLag set, nodes whose finalized head is more than MaxLag blocks behind the
+// best finalized head of the pool are not selected.
+type FinalityConfig struct {
+	Probe  bool  `yaml:"probe"`
+	MaxLag int64 `yaml:"max_lag"`
+}
+
+// ChaosConfig injects the faults into proxied requests once Enabled, for
+// testing clients. It must never be enabled for production traffic.
+type ChaosConfig struct {
+	Enabled bool         `yaml:"enabled"`
+	Faults  []ChaosFault `yaml:"faults"`
+}
+
+// ChaosFault is injected into Percentage percent of the requests calling one
+// of Methods, or any method when none are listed. Delay is in milliseconds,
+// Status is the HTTP status of errors unless they are JSON-RPC errors with
+// Code, stale requests are answered as of Blocks blocks behind the head.
+type ChaosFault struct {
+	Type       string   `yaml:"type"`
+	Methods    []string `yaml:"methods"`
+	Percentage float64  `yaml:"percentage"`
+	Delay      int      `yaml:"delay"`
+	Status     int      `yaml:"status"`
+	Code       int      `yaml:"code"`
+	Blocks     int64    `yaml:"blocks"`
+}
+
+// TransportConfig tunes the connection pool kept per node. Timeouts are in
+// seconds, MaxConns of zero means no limit.
+type TransportConfig struct {
+	MaxIdleConns    int  `yaml:"max_idle_conns"`
+	MaxConns        int  `yaml:"max_conns"`
+	IdleConnTimeout int  `yaml:"idle_conn_timeout"`
+	KeepAlive       int  `yaml:"keep_alive"`
+	DialTimeout     int  `yaml:"dial_timeout"`
+	DisableHTTP2    bool `yaml:"disable_http2"`
+}
+
+// GetLogsConfig splits eth_getLogs calls spanning more than MaxBlockRange
+// blocks into chunks, queried on up to Parallel nodes at once
+type GetLogsConfig struct {
+	MaxBlockRange int64 `yaml:"max_block_range"`
+	Parallel      int   `yaml:"parallel"`
+}
+
+// LimitsConfig bounds proxied requests. Sizes are in bytes and timeouts in
+// seconds, zero disables a limit.
+type LimitsConfig struct {
+	MaxRequestBytes  int64          `yaml:"max_request_bytes"`
+	MaxResponseBytes int64          `yaml:"max_response_bytes"`
+	Timeout          int            `yaml:"timeout"`
+	MethodTimeouts   map[string]int `yaml:"method_timeouts"`
+}
+
+// QuorumConfig sends calls of the listed methods to Nodes nodes and only
+// answers once a majority of them agrees on the result
+type QuorumConfig struct {
+	Nodes   int      `yaml:"nodes"`
+	Methods []string `yaml:"methods"`
+}
+
+// ShadowConfig mirrors a percentage of the read-only requests of a pool to a
+// shadow node whose responses are only compared, never returned
+type ShadowConfig struct {
+	Url        string   `yaml:"url"`
+	Percentage float64  `yaml:"percentage"`
+	Methods    []string `yaml:"methods"`
+}
+
+// CircuitBreakerConfig enables the per-node circuit breaker when FailureRatio
+// is set
+type CircuitBreakerConfig struct {
+	FailureRatio  float64 `yaml:"failure_ratio"`
+	Window        int     `yaml:"window"`
+	MinRequests   int     `yaml:"min_requests"`
+	CoolDown      int     `yaml:"cool_down"`
+	TrialRequests int     `yaml:"trial_requests"`
+}
+
+// NodeConfig is either a plain URL or a mapping with the URL and the node's
+// tier. Tier 0 nodes are preferred, higher tiers are only used as backups when
+// no node of a lower tier is available and in sync.
+type NodeConfig struct {
+	Url  string `yaml:"url"`
+	Tier int    `yaml:"tier"`
+	// Type is the provider of the node, generic by default. ApiKey is sent the
+	// way the provider expects and RateLimit in requests per second overrides
+	// the provider's.
+	Type      string  `yaml:"type"`
+	ApiKey    string  `yaml:"api_key"`
+	RateLimit float64 `yaml:"rate_limit"`
+}
+
+func (n *NodeConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&n.Url); err == nil {
+		return nil
+	}
+
+	type plain NodeConfig
+	return unmarshal((*plain)(n))
+}
+
+type TracingConfig struct {
+	Endpoint    string            `yaml:"endpoint"`
+	ServiceName string            `yaml:"service_name"`
+	Headers     map[string]string `yaml:"headers"`
+}
+
+// ValidationError lists every problem found in a configuration
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "Invalid config:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+func (e *ValidationError) addf(format string, args ...interface{}) {
+	e.Problems = append(e.Problems, fmt.Sprintf(format, args...))
+}
+
+// merge adds the problems of err with the given prefix
+func (e *ValidationError) merge(prefix string, err error) {
+	if err == nil {
+		return
+	}
+
+	if v, ok := err.(*ValidationError); ok {
+		for _, problem := range v.Problems {
+			e.Problems = append(e.Problems, prefix+problem)
+		}
+		return
+	}
+
+	e.Problems = append(e.Problems, prefix+err.Error())
+}
+
+// err returns nil, not a nil *ValidationError, when there are no problems
+func (e *ValidationError) err() error {
+	if len(e.Problems) == 0 {
+		return nil
+	}
+
+	return e
+}
+
+func ParseConfig(configPath string) (Config, error) {
+	return LoadConfig([]string{configPath})
+}
+
+// LoadConfig merges the config files, later ones overriding settings of
+// earlier ones, applies the overrides in order and validates the result
+func LoadConfig(configPaths []string, overrides ...map[string]string) (Config, error) {
+	problems := &ValidationError{}
+	tree := make(map[interface{}]interface{})
+
+	for _, configPath := range configPaths {
+		yamlFile, err := ioutil.ReadFile(configPath)
+
+		if err != nil {
+			return Config{}, errors.Errorf("Given path doesn't exist: %v", configPath)
+		}
+
+		var layer map[interface{}]interface{}
+		if err := yaml.Unmarshal(yamlFile, &layer); err != nil {
+			return Config{}, errors.Wrapf(err, "Unable to parse yaml: %v", configPath)
+		}
+		mergeTree(tree, layer)
+
+		// unknown keys and mistyped values are reported with the other
+		// problems, by file so that the line numbers make sense
+		if err := yaml.UnmarshalStrict(yamlFile, &Config{}); err != nil {
+			if typeError, ok := err.(*yaml.TypeError); ok {
+				for _, problem := range typeError.Errors {
+					problems.addf("%s: %s", configPath, problem)
+				}
+			}
+		}
+	}
+
+	for _, o := range overrides {
+		if err := applyOverrides(tree, o); err != nil {
+			return Config{}, err
+		}
+	}
+
+	merged, err := yaml.Marshal(tree)
+	if err != nil {
+		return Config{}, err
+	}
+
+	config := Config{}
+	if err := yaml.UnmarshalStrict(merged, &config); err != nil {
+		typeError, ok := err.(*yaml.TypeError)
+		if !ok {
+			return Config{}, errors.Wrap(err, "Unable to parse merged config")
+		}
+		// problems of the files are already reported
+		if len(problems.Problems) == 0 {
+			problems.Problems = append(problems.Problems, typeError.Errors...)
+		}
+	}
+
+	// only the LoadBalancer command listens on the port, see New
+	if config.Port <= 0 || config.Port > 65535 {
+		problems.addf("port must be within [1, 65535]: %v", config.Port)
+	}
+
+	problems.merge("", config.validate())
+	if err := problems.err(); err != nil {
+		return Config{}, err
+	}
+
+	return config, nil
+}
+
+// validate applies the defaults and reports all problems of the config at once
+func (c *Config) validate() error {
+	problems := &ValidationError{}
+
+	if len(c.Nodes) == 0 && len(c.Pools) == 0 {
+		problems.addf("Nodes are not defined")
+	}
+
+	if c.Name == "" {
+		c.Name = defaultPoolName
+	}
+
+	problems.merge("", c.PoolConfig.resolveAliases())
+	problems.merge("", c.PoolConfig.applyDefaults())
+
+	if c.History.RetentionDays < 0 {
+		problems.addf("history.retention_days must not be negative: %v", c.History.RetentionDays)
+	} else if c.History.RetentionDays == 0 {
+		c.History.RetentionDays = defaultHistoryRetentionDays
+	}
+
+	problems.merge("usage.", c.Usage.applyDefaults())
+
+	if c.Tracing.Endpoint != "" {
+		problems.merge("tracing.endpoint: ", validateUrl(c.Tracing.Endpoint))
+	}
+
+	names := map[string]bool{c.Name: len(c.Nodes) > 0}
+	for i := range c.Pools {
+		pool := &c.Pools[i]
+
+		prefix := fmt.Sprintf("Pool %s: ", pool.Name)
+		if pool.Name == "" {
+			prefix = fmt.Sprintf("Pool %d: ", i)
+			problems.addf("Pool %d has no name", i)
+		} else if names[pool.Name] {
+			problems.addf("Pool %s is defined twice", pool.Name)
+		}
+		names[pool.Name] = true
+
+		if len(pool.Nodes) == 0 {
+			problems.addf("%sNodes are not defined", prefix)
+		}
+		if pool.PathPrefix == "" && pool.Host == "" {
+			problems.addf("%sa path_prefix or host is required", prefix)
+		}
+
+		problems.merge(prefix, pool.resolveAliases())
+		pool.inherit(c.PoolConfig)
+		problems.merge(prefix, pool.applyDefaults())
+	}
+
+	return problems.err()
+}
+
+// validateUrl checks that a node or service url is an absolute http(s) url
+func validateUrl(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return errors.Wrap(redactError(err), "Invalid url")
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.Errorf("url scheme must be http or https: %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return errors.Errorf("url has no host")
+	}
+
+	return nil
+}
+
+// AllPools returns the default pool, if it has nodes, followed by the pools
+func (c Config) AllPools() []PoolConfig {
+	if len(c.Nodes) == 0 {
+		return c.Pools
+	}
+
+	return append([]PoolConfig{c.PoolConfig}, c.Pools...)
+}
+
+// inherit copies the settings the pool leaves unset from the top level
+func (p *PoolConfig) inherit(parent PoolConfig) {
+	if p.Interval == 0 {
+		p.Interval = parent.Interval
+	}
+	if p.BlockThreshold == 0 {
+		p.BlockThreshold = parent.BlockThreshold
+	}
+	if p.MaxHeadAge == 0 {
+		p.MaxHeadAge = parent.MaxHeadAge
+	}
+	if p.ConnectionTimeout == 0 {
+		p.ConnectionTimeout = parent.ConnectionTimeout
+	}
+	if p.ProbeWorkers == 0 {
+		p.ProbeWorkers = parent.ProbeWorkers
+	}
+	if p.Selection == "" {
+		p.Selection = parent.Selection
+	}
+	if p.LatencyTolerance == 0 {
+		p.LatencyTolerance = parent.LatencyTolerance
+	}
+	if p.LatencyAlpha == 0 {
+		p.LatencyAlpha = parent.LatencyAlpha
+	}
+	if p.CircuitBreaker == (CircuitBreakerConfig{}) {
+		p.CircuitBreaker = parent.CircuitBreaker
+	}
+	if p.Quorum.Nodes == 0 {
+		p.Quorum = parent.Quorum
+		// an inherited quorum is bounded by the pool's own nodes
+		if len(p.Nodes) > 0 && p.Quorum.Nodes > len(p.Nodes) {
+			p.Quorum.Nodes = len(p.Nodes)
+		}
+		if p.Quorum.Nodes < 2 {
+			p.Quorum = QuorumConfig{}
+		}
+	}
+	if p.Limits.MaxRequestBytes == 0 {
+		p.Limits.MaxRequestBytes = parent.Limits.MaxRequestBytes
+	}
+	if p.Limits.MaxResponseBytes == 0 {
+		p.Limits.MaxResponseBytes = parent.Limits.MaxResponseBytes
+	}
+	if p.Limits.Timeout == 0 {
+		p.Limits.Timeout = parent.Limits.Timeout
+	}
+	if p.Transport == (TransportConfig{}) {
+		p.Transport = parent.Transport
+	}
+	if p.GetLogs == (GetLogsConfig{}) {
+		p.GetLogs = parent.GetLogs
+	}
+	if p.Finality == (FinalityConfig{}) {
+		p.Finality = parent.Finality
+	}
+	if !p.Chaos.Enabled && len(p.Chaos.Faults) == 0 {
+		p.Chaos = parent.Chaos
+	}
+	for method, timeout := range parent.Limits.MethodTimeouts {
+		if _, ok := p.Limits.MethodTimeouts[method]; !ok {
+			if p.Limits.MethodTimeouts == nil {
+				p.Limits.MethodTimeouts = make(map[string]int)
+			}
+			p.Limits.MethodTimeouts[method] = timeout
+		}
+	}
+}
+
+// resolveAliases accepts block_treshold, the original spelling of
+// block_threshold
+func (p *PoolConfig) resolveAliases() error {
+	if p.BlockTreshold == 0 {
+		return nil
+	}
+
+	if p.BlockThreshold != 0 && p.BlockThreshold != p.BlockTreshold {
+		return errors.Errorf("block_threshold and block_treshold differ: %v, %v", p.BlockThreshold, p.BlockTreshold)
+	}
+
+	p.BlockThreshold, p.BlockTreshold = p.BlockTreshold, 0
+	return nil
+}
+
+func (p *PoolConfig) applyDefaults() error {
+	problems := &ValidationError{}
+
+	seen := make(map[string]bool)
+	for i, n := range p.Nodes {
+		problems.merge(fmt.Sprintf("nodes[%d]: ", i), validateUrl(n.Url))
+		if n.Tier < 0 {
+			problems.addf("nodes[%d]: tier must not be negative: %v", i, n.Tier)
+		}
+		if _, ok := providers[n.Type]; !ok && n.Type != "" {
+			problems.addf("nodes[%d]: unknown type: %v", i, n.Type)
+		}
+		if n.RateLimit < 0 {
+			problems.addf("nodes[%d]: rate_limit must not be negative: %v", i, n.RateLimit)
+		}
+		if seen[n.Url+n.ApiKey] {
+			problems.addf("nodes[%d]: node is listed twice", i)
+		}
+		seen[n.Url+n.ApiKey] = true
+	}
+
+	if len(p.Nodes) > 0 && p.Interval <= 0 {
+		problems.addf("check_interval must be positive: %v", p.Interval)
+	}
+
+	if p.ChainId < 0 {
+		problems.addf("chain_id must not be negative: %v", p.ChainId)
+	}
+
+	if p.BlockThreshold < 0 {
+		problems.addf("block_threshold must not be negative: %v", p.BlockThreshold)
+	}
+
+	if p.MaxHeadAge < 0 {
+		problems.addf("max_head_age must not be negative: %v", p.MaxHeadAge)
+	}
+
+	if p.ConnectionTimeout < 0 {
+		problems.addf("connection_timeout must not be negative: %v", p.ConnectionTimeout)
+	} else if p.ConnectionTimeout == 0 {
+		p.ConnectionTimeout = defaultConnectionTimeout
+	}
+
+	if p.ProbeWorkers < 0 {
+		problems.addf("probe_workers must not be negative: %v", p.ProbeWorkers)
+	} else if p.ProbeWorkers == 0 {
+		p.ProbeWorkers = defaultProbeWorkers
+	}
+
+	switch p.Selection {
+	case "":
+		p.Selection = SelectionBlock
+	case SelectionBlock, SelectionLatency:
+	default:
+		problems.addf("Unknown selection strategy: %v", p.Selection)
+	}
+
+	if p.LatencyTolerance < 0 {
+		problems.addf("latency_tolerance must not be negative: %v", p.LatencyTolerance)
+	}
+
+	if p.LatencyAlpha == 0 {
+		p.LatencyAlpha = defaultLatencyAlpha
+	} else if p.LatencyAlpha < 0 || p.LatencyAlpha > 1 {
+		problems.addf("latency_alpha must be within (0, 1]: %v", p.LatencyAlpha)
+	}
+
+	if p.Shadow.Url != "" {
+		problems.merge("shadow.url: ", validateUrl(p.Shadow.Url))
+	}
+	if p.Shadow.Percentage < 0 || p.Shadow.Percentage > 100 {
+		problems.addf("shadow.percentage must be within [0, 100]: %v", p.Shadow.Percentage)
+	}
+
+	if p.Quorum.Nodes < 0 || p.Quorum.Nodes == 1 {
+		problems.addf("quorum.nodes must be at least 2: %v", p.Quorum.Nodes)
+	}
+	if len(p.Nodes) > 0 && p.Quorum.Nodes > len(p.Nodes) {
+		problems.addf("quorum.nodes exceeds the number of nodes: %v", p.Quorum.Nodes)
+	}
+
+	if p.Limits.MaxRequestBytes < 0 || p.Limits.MaxResponseBytes < 0 || p.Limits.Timeout < 0 {
+		problems.addf("limits must not be negative")
+	}
+	for method, timeout := range p.Limits.MethodTimeouts {
+		if timeout <= 0 {
+			problems.addf("limits.method_timeouts.%s must be positive: %v", method, timeout)
+		}
+	}
+
+	if p.GetLogs.MaxBlockRange < 0 || p.GetLogs.Parallel < 0 {
+		problems.addf("get_logs must not be negative")
+	}
+	if p.GetLogs.Parallel == 0 {
+		p.GetLogs.Parallel = 1
+	}
+
+	transport := &p.Transport
+	if transport.MaxIdleConns < 0 || transport.MaxConns < 0 || transport.IdleConnTimeout < 0 ||
+		transport.KeepAlive < 0 || transport.DialTimeout < 0 {
+		problems.addf("transport settings must not be negative")
+	}
+	if transport.MaxIdleConns == 0 {
+		transport.MaxIdleConns = defaultMaxIdleConns
+	}
+	if transport.IdleConnTimeout == 0 {
+		transport.IdleConnTimeout = defaultIdleConnTimeout
+	}
+	if transport.KeepAlive == 0 {
+		transport.KeepAlive = defaultKeepAlive
+	}
+	if transport.DialTimeout == 0 {
+		transport.DialTimeout = p.ConnectionTimeout
+	}
+
+	if p.Finality.MaxLag < 0 {
+		problems.addf("finality.max_lag must not be negative: %v", p.Finality.MaxLag)
+	} else if p.Finality.MaxLag > 0 {
+		p.Finality.Probe = true
+	}
+
+	for i := range p.Chaos.Faults {
+		problems.merge(fmt.Sprintf("chaos.faults[%d]: ", i), p.Chaos.Faults[i].applyDefaults())
+	}
+
+	breaker := &p.CircuitBreaker
+	if breaker.FailureRatio < 0 || breaker.FailureRatio > 1 {
+		problems.addf("circuit_breaker.failure_ratio must be within [0, 1]: %v", breaker.FailureRatio)
+	}
+	if breaker.Window < 0 || breaker.MinRequests < 0 || breaker.CoolDown < 0 || breaker.TrialRequests < 0 {
+		problems.addf("circuit_breaker settings must not be negative")
+	}
+	if breaker.Window <= 0 {
+		breaker.Window = defaultBreakerWindow
+	}
+	if breaker.MinRequests <= 0 {
+		breaker.MinRequests = defaultBreakerMinRequests
+	}
+	if breaker.MinRequests > breaker.Window {
+		breaker.MinRequests = breaker.Window
+	}
+	if breaker.CoolDown <= 0 {
+		breaker.CoolDown = defaultBreakerCoolDown
+	}
+	if breaker.TrialRequests <= 0 {
+		breaker.TrialRequests = defaultBreakerTrialRequests
+	}
+
+	return problems.err()
+}
+
+func (u *UsageConfig) applyDefaults() error {
+	problems := &ValidationError{}
+
+	if u.Header == "" {
+		u.Header = defaultUsageHeader
+	}
+	if u.DefaultWeight < 0 {
+		problems.addf("default_weight must not be negative: %v", u.DefaultWeight)
+	} else if u.DefaultWeight == 0 {
+		u.DefaultWeight = 1
+	}
+	for method, weight := range u.Weights {
+		if weight < 0 {
+			problems.addf("weights.%s must not be negative: %v", method, weight)
+		}
+	}
+
+	if u.Interval < 0 {
+		problems.addf("interval must not be negative: %v", u.Interval)
+	} else if u.Interval == 0 {
+		u.Interval = defaultUsageInterval
+	}
+	if u.RetentionHours < 0 {
+		problems.addf("retention_hours must not be negative: %v", u.RetentionHours)
+	} else if u.RetentionHours == 0 {
+		u.RetentionHours = defaultUsageRetention
+	}
+	if u.MaxClients < 0 {
+		problems.addf("max_clients must not be negative: %v", u.MaxClients)
+	} else if u.MaxClients == 0 {
+		u.MaxClients = defaultUsageMaxClients
+	}
+
+	return problems.err()
+}
+
+func (f *ChaosFault) applyDefaults() error {
+	problems := &ValidationError{}
+
+	switch f.Type {
+	case ChaosLatency:
+		if f.Delay == 0 {
+			f.Delay = defaultChaosDelay
+		}
+	case ChaosError:
+		if f.Status == 0 {
+			f.Status = defaultChaosStatus
+		} else if f.Status < 400 || f.Status > 599 {
+			problems.addf("status must be an HTTP error status: %v", f.Status)
+		}
+	case ChaosDrop:
+	case ChaosStale:
+		if f.Blocks == 0 {
+			f.Blocks = defaultChaosBlocks
+		}
+	default:
+		problems.addf("Unknown fault type: %q", f.Type)
+	}
+
+	if f.Percentage < 0 || f.Percentage > 100 {
+		problems.addf("percentage must be within [0, 100]: %v", f.Percentage)
+	}
+	if f.Delay < 0 || f.Blocks < 0 {
+		problems.addf("delay and blocks must not be negative")
+	}
+
+	return problems.err()
+}
@@ -0,0 +1,103 @@
+package balancer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeConfigs writes the config files to a temporary directory and returns
+// their paths along with a function removing them
+func writeConfigs(t *testing.T, contents ...string) ([]string, func()) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	for i, content := range contents {
+		path := filepath.Join(dir, string(rune('a'+i))+".yml")
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	return paths, func() { os.RemoveAll(dir) }
+}
+
+func TestLoadConfigProblems(t *testing.T) {
+	cases := []struct {
+		name     string
+		config   string
+		problems []string
+	}{
+		{"valid", "port: 8000\ncheck_interval: 30\nnodes: [http://localhost:8545]\n", nil},
+		{"every problem", "port: 0\ncheck_interval: -1\nnodes: [ftp://node, {url: http://node, tier: -1}]\n",
+			[]string{"port must be within", "nodes[0]:", "nodes[1]: tier must not be negative", "check_interval must be positive"}},
+		{"no nodes", "port: 8000\ncheck_interval: 30\n", []string{"Nodes are not defined"}},
+		{"unknown key", "port: 8000\ncheck_interval: 30\nnodes: [http://localhost:8545]\nblock_treshhold: 5\n",
+			[]string{"field block_treshhold not found"}},
+		{"mistyped value", "port: 8000\ncheck_interval: 30\nnodes: [http://localhost:8545]\nblock_threshold: ten\n",
+			[]string{"a.yml: line 4: cannot unmarshal !!str `ten`"}},
+		{"both spellings differ", "port: 8000\ncheck_interval: 30\nnodes: [http://localhost:8545]\nblock_threshold: 5\nblock_treshold: 6\n",
+			[]string{"block_threshold and block_treshold differ"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			paths, remove := writeConfigs(t, c.config)
+			defer remove()
+
+			_, err := LoadConfig(paths)
+			if len(c.problems) == 0 {
+				if err != nil {
+					t.Fatalf("error %v, want none", err)
+				}
+				return
+			}
+
+			v, ok := err.(*ValidationError)
+			if !ok {
+				t.Fatalf("error %v, want a ValidationError", err)
+			}
+			if len(v.Problems) != len(c.problems) {
+				t.Errorf("problems %q, want %d", v.Problems, len(c.problems))
+			}
+			for _, want := range c.problems {
+				if !strings.Contains(v.Error(), want) {
+					t.Errorf("problems %q, want one containing %q", v.Problems, want)
+				}
+			}
+		})
+	}
+}
+
+func TestBlockThresholdSpellings(t *testing.T) {
+	cases := map[string]string{
+		"block_threshold": "block_threshold: 5\n",
+		"block_treshold":  "block_treshold: 5\n",
+		"both equal":      "block_threshold: 5\nblock_treshold: 5\n",
+	}
+
+	for name, threshold := range cases {
+		t.Run(name, func(t *testing.T) {
+			paths, remove := writeConfigs(t, "port: 8000\ncheck_interval: 30\nnodes: [http://localhost:8545]\n"+threshold+
+				"pools:\n  - name: side\n    path_prefix: /side\n    nodes: [http://localhost:8546]\n")
+			defer remove()
+
+			config, err := LoadConfig(paths)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.BlockThreshold != 5 || config.BlockTreshold != 0 {
+				t.Errorf("block_threshold %d, block_treshold %d, want 5 and 0", config.BlockThreshold, config.BlockTreshold)
+			}
+			if pool := config.Pools[0]; pool.BlockThreshold != 5 {
+				t.Errorf("block_threshold of the pool %d, want the inherited 5", pool.BlockThreshold)
+			}
+		})
+	}
+}
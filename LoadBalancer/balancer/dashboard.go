@@ -0,0 +1,240 @@
+package balancer
+
+import (
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// dashboardSamples is the number of observe rounds kept per node for the
+	// dashboard sparklines
+	dashboardSamples = 60
+
+	minDashboardRefresh = 5
+)
+
+// recentSample is the state of a node after an observe round, kept in memory
+// for the dashboard
+type recentSample struct {
+	time      time.Time
+	available bool
+	lagging   bool
+	requests  int64
+}
+
+// recordRecent appends a sample of every node to its recent samples. Must be
+// called with the pool lock held.
+func (p *Pool) recordRecent() {
+	now := time.Now()
+	head := headBlock(p.Nodes)
+
+	for i := range p.Nodes {
+		n := &p.Nodes[i]
+		sample := recentSample{
+			time:      now,
+			available: n.Available && !n.Forked,
+			lagging:   head-n.BlockNumber > p.Config.BlockThreshold,
+			requests:  n.Requests,
+		}
+
+		// copied rather than appended in place, node snapshots share the slice
+		start := 0
+		if len(n.recent) >= dashboardSamples {
+			start = len(n.recent) - dashboardSamples + 1
+		}
+		n.recent = append(append(make([]recentSample, 0, dashboardSamples), n.recent[start:]...), sample)
+	}
+}
+
+type sparkBar struct {
+	X     int
+	Color string
+}
+
+type dashboardNode struct {
+	Url         string
+	Tier        int
+	Current     bool
+	Status      string
+	StatusClass string
+	Block       int64
+	Lag         string
+	FinalLag    string
+	Latency     string
+	Rate        float64
+	Uptime      float64
+	Bars        []sparkBar
+	SparkWidth  int
+}
+
+type dashboardPool struct {
+	Name       string
+	PathPrefix string
+	Host       string
+	Head       int64
+	Stale      bool
+	Current    string
+	Nodes      []dashboardNode
+}
+
+func (p *Pool) dashboard() dashboardPool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	head, maxFinalized := headBlock(p.Nodes), finalizedHead(p.Nodes)
+	pool := dashboardPool{
+		Name:       p.Config.Name,
+		PathPrefix: p.Config.PathPrefix,
+		Host:       p.Config.Host,
+		Head:       head,
+		Stale:      p.Stale,
+	}
+
+	for i, n := range p.Nodes {
+		node := dashboardNode{
+			Url:        n.String(),
+			Tier:       n.Tier,
+			Current:    i == p.CurrentNodeId,
+			Block:      n.BlockNumber,
+			Lag:        "-",
+			FinalLag:   "-",
+			Latency:    "-",
+			SparkWidth: 4 * dashboardSamples,
+		}
+
+		switch {
+		case !n.Available:
+			node.Status, node.StatusClass = "unavailable", "down"
+		case n.Forked:
+			node.Status, node.StatusClass = "forked", "down"
+		case n.Circuit.State() == CircuitOpen:
+			node.Status, node.StatusClass = "circuit open", "down"
+		case n.backingOff():
+			node.Status, node.StatusClass = "backing off", "warn"
+		case head-n.BlockNumber > p.Config.BlockThreshold:
+			node.Status, node.StatusClass = "lagging", "warn"
+		case !finalityInSync(n, maxFinalized, p.Config):
+			node.Status, node.StatusClass = "finality lagging", "warn"
+		default:
+			node.Status, node.StatusClass = "synced", "up"
+		}
+
+		if n.Available {
+			node.Lag = strconv.FormatInt(head-n.BlockNumber, 10)
+			if p.Config.Finality.Probe {
+				node.FinalLag = strconv.FormatInt(maxFinalized-n.FinalizedBlock, 10)
+			}
+		}
+		if n.Latency > 0 {
+			node.Latency = n.Latency.Round(time.Millisecond).String()
+		}
+
+		up := 0
+		for j, s := range n.recent {
+			bar := sparkBar{X: 4 * j, Color: "#1a7f37"}
+			if !s.available {
+				bar.Color = "#cf222e"
+			} else if s.lagging {
+				bar.Color = "#bf8700"
+			}
+			if s.available {
+				up++
+			}
+			node.Bars = append(node.Bars, bar)
+		}
+		if len(n.recent) > 0 {
+			node.Uptime = 100 * float64(up) / float64(len(n.recent))
+		}
+
+		// proxied requests per second over the last observe interval
+		if k := len(n.recent); k >= 2 {
+			last, prev := n.recent[k-1], n.recent[k-2]
+			if elapsed := last.time.Sub(prev.time).Seconds(); elapsed > 0 {
+				node.Rate = float64(last.requests-prev.requests) / elapsed
+			}
+		}
+
+		if node.Current {
+			pool.Current = node.Url
+		}
+		pool.Nodes = append(pool.Nodes, node)
+	}
+
+	return pool
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="{{.Refresh}}">
+<title>LoadBalancer</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #24292f; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { padding: 0.3em 0.8em; text-align: left; border-bottom: 1px solid #d0d7de; white-space: nowrap; }
+td.num { text-align: right; font-variant-numeric: tabular-nums; }
+tr.current { background: #ddf4ff; font-weight: bold; }
+.up { color: #1a7f37; }
+.warn { color: #bf8700; }
+.down { color: #cf222e; }
+small { color: #57606a; font-weight: normal; }
+</style>
+</head>
+<body>
+<h1>LoadBalancer</h1>
+<p><small>Updated {{.Time.Format "2006-01-02 15:04:05 MST"}}, refreshes every {{.Refresh}} seconds</small></p>
+{{range .Pools}}
+<h2>{{.Name}} {{if .PathPrefix}}<small>{{.PathPrefix}}</small>{{end}} {{if .Host}}<small>{{.Host}}</small>{{end}}</h2>
+<p>Head block {{.Head}}{{if .Stale}} <span class="down">stale</span>{{end}}, current node {{if .Current}}{{.Current}}{{else}}<span class="down">none available</span>{{end}}</p>
+<table>
+<tr><th>Node</th><th>Tier</th><th>Status</th><th>Block</th><th>Lag</th><th>Finalized lag</th><th>Latency</th><th>Requests/s</th><th>Availability</th></tr>
+{{range .Nodes}}<tr{{if .Current}} class="current"{{end}}>
+<td>{{.Url}}</td>
+<td class="num">{{.Tier}}</td>
+<td class="{{.StatusClass}}">{{.Status}}</td>
+<td class="num">{{.Block}}</td>
+<td class="num">{{.Lag}}</td>
+<td class="num">{{.FinalLag}}</td>
+<td class="num">{{.Latency}}</td>
+<td class="num">{{printf "%.2f" .Rate}}</td>
+<td><svg width="{{.SparkWidth}}" height="16">{{range .Bars}}<rect x="{{.X}}" width="3" height="16" fill="{{.Color}}"/>{{end}}</svg> {{printf "%.0f" .Uptime}}%</td>
+</tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+// dashboardHandler renders the state of all pools as a self-refreshing HTML
+// page, a human friendly version of /info
+func dashboardHandler(pools []*Pool) http.HandlerFunc {
+	refresh := 0
+	for _, p := range pools {
+		if refresh == 0 || p.Config.Interval < refresh {
+			refresh = p.Config.Interval
+		}
+	}
+	if refresh < minDashboardRefresh {
+		refresh = minDashboardRefresh
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := struct {
+			Time    time.Time
+			Refresh int
+			Pools   []dashboardPool
+		}{Time: time.Now(), Refresh: refresh}
+
+		for _, p := range pools {
+			data.Pools = append(data.Pools, p.dashboard())
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := dashboardTemplate.Execute(w, data); err != nil {
+			Error.Printf("Rendering the dashboard failed with: %v", err)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package balancer
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDashboard(t *testing.T) {
+	a, b, c := newFakeNode(100), newFakeNode(90), newFakeNode(100)
+	defer a.Close()
+	defer b.Close()
+	defer c.Close()
+
+	pool := newTestPool(t, []*fakeNode{a, b, c}, func(c *PoolConfig) { c.BlockThreshold = 5 })
+	c.setStatus(http.StatusInternalServerError)
+	observeTest(pool)
+	observeTest(pool)
+
+	dashboard := pool.dashboard()
+	if dashboard.Head != 100 || dashboard.Current != a.server.URL {
+		t.Errorf("head %d, current %s, want 100 and %s", dashboard.Head, dashboard.Current, a.server.URL)
+	}
+
+	want := []struct {
+		status, class, lag string
+		bars               int
+	}{
+		{"synced", "up", "0", 2},
+		{"lagging", "warn", "10", 2},
+		{"unavailable", "down", "-", 2},
+	}
+	for i, w := range want {
+		n := dashboard.Nodes[i]
+		if n.Status != w.status || n.StatusClass != w.class || n.Lag != w.lag || len(n.Bars) != w.bars {
+			t.Errorf("node %d: status %q (%s), lag %s, %d bars, want %q (%s), lag %s, %d bars",
+				i, n.Status, n.StatusClass, n.Lag, len(n.Bars), w.status, w.class, w.lag, w.bars)
+		}
+	}
+	if uptime := dashboard.Nodes[2].Uptime; uptime != 0 {
+		t.Errorf("uptime of the unavailable node %v, want 0", uptime)
+	}
+
+	proxy := newTestProxy(pool)
+	defer proxy.Close()
+
+	resp, err := http.Get(proxy.URL + "/dashboard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "text/html") {
+		t.Errorf("Content-Type = %q, want text/html", contentType)
+	}
+	page := string(body)
+	for _, s := range []string{
+		`content="5"`,
+		`<tr class="current">` + "\n<td>" + a.server.URL,
+		`<td class="warn">lagging</td>`,
+		`<td class="down">unavailable</td>`,
+	} {
+		if !strings.Contains(page, s) {
+			t.Errorf("dashboard does not contain %q", s)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package balancer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	EventNodeUnavailable = "node_unavailable"
+	EventNodeAvailable   = "node_available"
+	EventNodeLagging     = "node_lagging"
+	EventNodeSynced      = "node_synced"
+	EventNodeForked      = "node_forked"
+	EventFailover        = "failover"
+	EventNewBlock        = "new_block"
+	EventPoolStale       = "pool_stale"
+	EventPoolResumed     = "pool_resumed"
+
+	eventHistorySize      = 100
+	eventSubscriberBuffer = 64
+	eventHeartbeat        = 15 * time.Second
+)
+
+// Event is a node state transition pushed to /events subscribers
+type Event struct {
+	Id    int64     `json:"id"`
+	Type  string    `json:"type"`
+	Time  time.Time `json:"time"`
+	Pool  string    `json:"pool"`
+	Node  string    `json:"node,omitempty"`
+	From  string    `json:"from,omitempty"`
+	To    string    `json:"to,omitempty"`
+	Block int64     `json:"block,omitempty"`
+}
+
+// EventHub fans events out to subscribers and keeps the latest ones so that
+// reconnecting clients can catch up using Last-Event-ID
+type EventHub struct {
+	mu          sync.Mutex
+	nextId      int64
+	history     []Event
+	subscribers map[chan Event]struct{}
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{subscribers: make(map[chan Event]struct{})}
+}
+
+func (h *EventHub) Publish(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.nextId++
+	e.Id = h.nextId
+	e.Time = time.Now()
+
+	h.history = append(h.history, e)
+	if len(h.history) > eventHistorySize {
+		h.history = h.history[1:]
+	}
+
+	for ch := range h.subscribers {
+		select {
+		case ch <- e:
+		default:
+			Warning.Printf("Event subscriber is too slow, dropping event %d", e.Id)
+		}
+	}
+}
+
+// Subscribe returns a channel receiving new events together with the kept
+// events newer than lastId
+func (h *EventHub) Subscribe(lastId int64) (chan Event, []Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var missed []Event
+	for _, e := range h.history {
+		if e.Id > lastId {
+			missed = append(missed, e)
+		}
+	}
+
+	ch := make(chan Event, eventSubscriberBuffer)
+	h.subscribers[ch] = struct{}{}
+
+	return ch, missed
+}
+
+func (h *EventHub) Unsubscribe(ch chan Event) {
+	h.mu.Lock()
+	delete(h.subscribers, ch)
+	h.mu.Unlock()
+}
+
+// publishTransitions compares the nodes before and after an observe round.
+// Must be called with the pool lock held.
+func (p *Pool) publishTransitions(prev []Node, prevCurrent int) {
+	name := p.Config.Name
+	prevHead, head := headBlock(prev), headBlock(p.Nodes)
+
+	for i, n := range p.Nodes {
+		node := n.String()
+		was := prev[i]
+
+		switch {
+		case was.Available && !n.Available:
+			p.events.Publish(Event{Type: EventNodeUnavailable, Pool: name, Node: node})
+		case !was.Available && n.Available:
+			p.events.Publish(Event{Type: EventNodeAvailable, Pool: name, Node: node, Block: n.BlockNumber})
+		}
+
+		if !was.Forked && n.Forked {
+			p.events.Publish(Event{Type: EventNodeForked, Pool: name, Node: node, Block: n.BlockNumber})
+		}
+
+		wasLagging := was.Available && prevHead-was.BlockNumber > p.Config.BlockThreshold
+		lagging := n.Available && head-n.BlockNumber > p.Config.BlockThreshold
+		switch {
+		case !wasLagging && lagging:
+			p.events.Publish(Event{Type: EventNodeLagging, Pool: name, Node: node, Block: n.BlockNumber})
+		case wasLagging && !lagging && n.Available:
+			p.events.Publish(Event{Type: EventNodeSynced, Pool: name, Node: node, Block: n.BlockNumber})
+		}
+	}
+
+	if p.CurrentNodeId != prevCurrent {
+		e := Event{Type: EventFailover, Pool: name}
+		if prevCurrent >= 0 {
+			e.From = p.Nodes[prevCurrent].String()
+		}
+		if p.CurrentNodeId >= 0 {
+			e.To = p.Nodes[p.CurrentNodeId].String()
+		}
+		p.events.Publish(e)
+	}
+
+	if head > prevHead {
+		p.events.Publish(Event{Type: EventNewBlock, Pool: name, Block: head})
+	}
+}
+
+// eventsHandler streams events of the hub as Server-Sent Events, optionally
+// filtered by the pool query parameter, until the client goes away or ctx is
+// done
+func eventsHandler(ctx context.Context, events *EventHub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		lastId, _ := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+		pool := r.URL.Query().Get("pool")
+
+		ch, missed := events.Subscribe(lastId)
+		defer events.Unsubscribe(ch)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusOK)
+
+		send := func(e Event) {
+			if pool != "" && e.Pool != pool {
+				return
+			}
+
+			data, err := json.Marshal(e)
+			if err != nil {
+				Error.Printf("Encoding event failed with: %v", err)
+				return
+			}
+
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Id, e.Type, data)
+		}
+
+		for _, e := range missed {
+			send(e)
+		}
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(eventHeartbeat)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case e := <-ch:
+				send(e)
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": heartbeat\n\n")
+			case <-r.Context().Done():
+				return
+			case <-ctx.Done():
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
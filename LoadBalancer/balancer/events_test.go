@@ -0,0 +1,112 @@
+package balancer
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPublishTransitions(t *testing.T) {
+	cases := []struct {
+		name   string
+		change func(a, b *fakeNode)
+		want   []string
+	}{
+		{"node down", func(a, b *fakeNode) { a.setStatus(http.StatusInternalServerError) }, []string{EventNodeUnavailable, EventFailover}},
+		{"node lagging", func(a, b *fakeNode) { a.setBlock(110) }, []string{EventNodeLagging, EventNewBlock}},
+		{"new block", func(a, b *fakeNode) { a.setBlock(101) }, []string{EventNewBlock}},
+		{"nothing changed", func(a, b *fakeNode) {}, nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			a, b := newFakeNode(100), newFakeNode(99)
+			defer a.Close()
+			defer b.Close()
+
+			pool := newTestPool(t, []*fakeNode{a, b}, func(c *PoolConfig) { c.BlockThreshold = 5 })
+			if current := observeTest(pool); current != 0 {
+				t.Fatalf("current = %d, want 0", current)
+			}
+
+			ch, observed := pool.events.Subscribe(0)
+			pool.events.Unsubscribe(ch)
+			lastId := observed[len(observed)-1].Id
+
+			c.change(a, b)
+			observeTest(pool)
+
+			ch, events := pool.events.Subscribe(lastId)
+			pool.events.Unsubscribe(ch)
+
+			var types []string
+			for _, e := range events {
+				types = append(types, e.Type)
+			}
+			if !reflect.DeepEqual(types, c.want) {
+				t.Errorf("events %v, want %v", types, c.want)
+			}
+		})
+	}
+}
+
+// readEvent reads the next event of an SSE stream, skipping heartbeats
+func readEvent(t *testing.T, r *bufio.Reader) Event {
+	var e Event
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.HasPrefix(line, "data: ") {
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil {
+				t.Fatal(err)
+			}
+		} else if line == "\n" && e.Id != 0 {
+			return e
+		}
+	}
+}
+
+func TestEventsStream(t *testing.T) {
+	a := newFakeNode(100)
+	defer a.Close()
+
+	pool := newTestPool(t, []*fakeNode{a}, nil)
+	proxy := newTestProxy(pool)
+	defer proxy.Close()
+
+	pool.events.Publish(Event{Type: EventNewBlock, Pool: "main", Block: 100})
+	pool.events.Publish(Event{Type: EventNewBlock, Pool: "other", Block: 200})
+	pool.events.Publish(Event{Type: EventNewBlock, Pool: "main", Block: 101})
+
+	req, _ := http.NewRequest(http.MethodGet, proxy.URL+"/events?pool=main", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if contentType := resp.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", contentType)
+	}
+	r := bufio.NewReader(resp.Body)
+
+	// the missed event of the pool, skipping the other pool
+	if e := readEvent(t, r); e.Id != 3 || e.Block != 101 {
+		t.Errorf("missed event %+v, want id 3 at block 101", e)
+	}
+
+	pool.events.Publish(Event{Type: EventNewBlock, Pool: "other", Block: 201})
+	pool.events.Publish(Event{Type: EventFailover, Pool: "main", To: "node"})
+	if e := readEvent(t, r); e.Id != 5 || e.Type != EventFailover {
+		t.Errorf("live event %+v, want the failover with id 5", e)
+	}
+}
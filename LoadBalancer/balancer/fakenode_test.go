@@ -0,0 +1,303 @@
+package balancer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	InitLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+// fakeNode is an in-process Ethereum JSON-RPC node. Its head, latency, chain
+// and failures can be changed while a test runs.
+type fakeNode struct {
+	server *httptest.Server
+
+	mu      sync.Mutex
+	block   int64
+	chainId int64
+	latency time.Duration
+	// status answers every request with this HTTP status when not zero
+	status int
+	// rpcError answers every call with a JSON-RPC error when not nil
+	rpcError *JSONRPCError
+	// fork changes the hashes of the blocks from forkBlock on, simulating a
+	// node on another chain
+	fork      int64
+	forkBlock int64
+	// finalized is the finalized head, -1 for a pre-merge node. The safe head
+	// is halfway between it and the head.
+	finalized int64
+	// headTime is the timestamp of the head, now when zero, blocks are 12
+	// seconds apart
+	headTime time.Time
+	requests map[string]int
+	// path and authorization of the last request
+	path          string
+	authorization string
+}
+
+func newFakeNode(block int64) *fakeNode {
+	n := &fakeNode{block: block, chainId: 1337, finalized: block - 64, requests: make(map[string]int)}
+	if n.finalized < 0 {
+		n.finalized = 0
+	}
+	n.server = httptest.NewServer(http.HandlerFunc(n.serveHTTP))
+
+	return n
+}
+
+func (n *fakeNode) Close() {
+	n.server.Close()
+}
+
+func (n *fakeNode) setBlock(block int64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.block = block
+}
+
+func (n *fakeNode) setLatency(latency time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.latency = latency
+}
+
+func (n *fakeNode) setStatus(status int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.status = status
+}
+
+func (n *fakeNode) setRPCError(err *JSONRPCError) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.rpcError = err
+}
+
+func (n *fakeNode) setChainId(chainId int64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.chainId = chainId
+}
+
+// setFork makes the node report different hashes from block on
+func (n *fakeNode) setFork(block int64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.fork, n.forkBlock = 1, block
+}
+
+func (n *fakeNode) setHeadTime(headTime time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.headTime = headTime
+}
+
+func (n *fakeNode) setFinalized(block int64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.finalized = block
+}
+
+// lastRequest returns the path and Authorization header of the last request
+func (n *fakeNode) lastRequest() (path string, authorization string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.path, n.authorization
+}
+
+// calls returns the number of calls of a method the node answered
+func (n *fakeNode) calls(method string) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.requests[method]
+}
+
+func (n *fakeNode) hash(number int64) string {
+	salt := int64(0)
+	if n.fork != 0 && number >= n.forkBlock {
+		salt = n.fork
+	}
+
+	return fmt.Sprintf("0x%064x", number*1000+salt)
+}
+
+type fakeCall struct {
+	Id     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+type fakeReply struct {
+	Version string          `json:"jsonrpc"`
+	Id      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *JSONRPCError   `json:"error,omitempty"`
+}
+
+func (n *fakeNode) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	latency, status := n.latency, n.status
+	n.path, n.authorization = r.URL.Path, r.Header.Get("Authorization")
+	n.mu.Unlock()
+
+	time.Sleep(latency)
+	if status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var batch []fakeCall
+	if err := json.Unmarshal(body, &batch); err == nil {
+		replies := make([]fakeReply, len(batch))
+		for i, call := range batch {
+			replies[i] = n.reply(call)
+		}
+		json.NewEncoder(w).Encode(replies)
+		return
+	}
+
+	var call fakeCall
+	if err := json.Unmarshal(body, &call); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(n.reply(call))
+}
+
+func (n *fakeNode) reply(call fakeCall) fakeReply {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.requests[call.Method]++
+	reply := fakeReply{Version: "2.0", Id: call.Id}
+	if n.rpcError != nil {
+		reply.Error = n.rpcError
+		return reply
+	}
+
+	switch call.Method {
+	case "eth_chainId":
+		reply.Result = fmt.Sprintf("0x%x", n.chainId)
+	case "eth_blockNumber":
+		reply.Result = fmt.Sprintf("0x%x", n.block)
+	case "eth_getBlockByNumber":
+		number := n.block
+		if len(call.Params) > 0 {
+			var tag string
+			json.Unmarshal(call.Params[0], &tag)
+			if tag == "finalized" || tag == "safe" {
+				if n.finalized < 0 {
+					break
+				}
+				number = n.finalized
+				if tag == "safe" {
+					number = (n.finalized + n.block) / 2
+				}
+			} else if parsed, err := strconv.ParseInt(tag, 0, 64); err == nil && parsed <= n.block {
+				number = parsed
+			}
+		}
+		headTime := n.headTime
+		if headTime.IsZero() {
+			headTime = time.Now()
+		}
+		reply.Result = map[string]string{
+			"number":     fmt.Sprintf("0x%x", number),
+			"hash":       n.hash(number),
+			"parentHash": n.hash(number - 1),
+			"timestamp":  fmt.Sprintf("0x%x", headTime.Unix()-(n.block-number)*12),
+		}
+	case "eth_getTransactionCount":
+		// the nonce is the block asked for, so nodes agree on a given block only
+		number := n.block
+		if len(call.Params) > 1 {
+			var tag string
+			json.Unmarshal(call.Params[1], &tag)
+			if parsed, err := strconv.ParseInt(tag, 0, 64); err == nil && parsed <= n.block {
+				number = parsed
+			}
+		}
+		reply.Result = fmt.Sprintf("0x%x", number)
+	case "eth_getBalance":
+		// identifies the node answering a proxied request
+		reply.Result = n.server.URL
+	case "eth_getLogs":
+		var filter struct {
+			FromBlock string `json:"fromBlock"`
+			ToBlock   string `json:"toBlock"`
+		}
+		if len(call.Params) > 0 {
+			json.Unmarshal(call.Params[0], &filter)
+		}
+		from, _ := strconv.ParseInt(filter.FromBlock, 0, 64)
+		to, _ := strconv.ParseInt(filter.ToBlock, 0, 64)
+		logs := []map[string]string{}
+		for b := from; b <= to; b++ {
+			logs = append(logs, map[string]string{"blockNumber": fmt.Sprintf("0x%x", b)})
+		}
+		reply.Result = logs
+	default:
+		reply.Error = &JSONRPCError{Code: -32601, Message: "Method not found"}
+	}
+
+	return reply
+}
+
+// newTestPool returns a pool of the fake nodes, all in tier 0, configured by
+// configure before the defaults are applied
+func newTestPool(t *testing.T, nodes []*fakeNode, configure func(*PoolConfig)) *Pool {
+	config := PoolConfig{Name: defaultPoolName, Interval: 1, ConnectionTimeout: 1}
+	for _, n := range nodes {
+		config.Nodes = append(config.Nodes, NodeConfig{Url: n.server.URL})
+	}
+	if configure != nil {
+		configure(&config)
+	}
+
+	if err := config.applyDefaults(); err != nil {
+		t.Fatal(err)
+	}
+
+	pool, err := NewPool(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pool
+}
+
+// observeTest runs an observe round of the pool and returns its current node
+func observeTest(pool *Pool) int {
+	pool.observe(context.Background())
+
+	return pool.current()
+}
@@ -0,0 +1,68 @@
+package balancer
+
+import (
+	"context"
+)
+
+// probeFinality returns the finalized and safe heads of a post-merge node. A
+// head the node does not report, e.g. before the merge, is left at zero.
+func probeFinality(ctx context.Context, node *Node) (finalized int64, safe int64) {
+	if block, err := getBlock(ctx, node, "finalized"); err != nil {
+		Warning.Printf("Getting the finalized block of %s failed with: %v", node, err)
+	} else {
+		finalized = block.Number
+	}
+
+	if block, err := getBlock(ctx, node, "safe"); err != nil {
+		Warning.Printf("Getting the safe block of %s failed with: %v", node, err)
+	} else {
+		safe = block.Number
+	}
+
+	return finalized, safe
+}
+
+// finalizedHead is the best finalized head of the available nodes
+func finalizedHead(nodes []Node) (maxFinalized int64) {
+	for _, n := range nodes {
+		if n.Available && !n.Forked && n.FinalizedBlock > maxFinalized {
+			maxFinalized = n.FinalizedBlock
+		}
+	}
+
+	return maxFinalized
+}
+
+// finalityInSync reports whether the finalized head of the node is within
+// finality.max_lag blocks of the best one, always true without the policy
+func finalityInSync(node Node, maxFinalized int64, config PoolConfig) bool {
+	return config.Finality.MaxLag == 0 || maxFinalized-node.FinalizedBlock <= config.Finality.MaxLag
+}
+
+// selectionHeads returns the head the block threshold applies to, the best
+// one of the nodes meeting the finality policy, and the best finalized head
+func selectionHeads(nodes []Node, config PoolConfig) (maxBlock int64, maxFinalized int64) {
+	maxFinalized = finalizedHead(nodes)
+	for _, n := range nodes {
+		if n.Available && !n.Forked && finalityInSync(n, maxFinalized, config) && n.BlockNumber > maxBlock {
+			maxBlock = n.BlockNumber
+		}
+	}
+
+	return maxBlock, maxFinalized
+}
+
+// accountFinality updates the finalized lag of the nodes. Must be called with
+// the pool lock held.
+func (p *Pool) accountFinality() {
+	if !p.Config.Finality.Probe {
+		return
+	}
+
+	maxFinalized := finalizedHead(p.Nodes)
+	for _, n := range p.Nodes {
+		if n.Available {
+			p.metrics.nodeFinalizedLag.Set(float64(maxFinalized-n.FinalizedBlock), n.String())
+		}
+	}
+}
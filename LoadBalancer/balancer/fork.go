@@ -0,0 +1,96 @@
+package balancer
+
+// detectReorg compares a node's new head with the previous one. Must be called
+// with the pool lock held before the node is updated.
+func detectReorg(node Node, block Block, m *metrics) {
+	if node.BlockHash == "" {
+		return
+	}
+
+	reorged := block.Number < node.BlockNumber ||
+		(block.Number == node.BlockNumber && block.Hash != node.BlockHash) ||
+		(block.Number == node.BlockNumber+1 && block.ParentHash != node.BlockHash)
+
+	if reorged {
+		Warning.Printf("Node %s reorganized from block %d (%s) to %d (%s)",
+			node.String(), node.BlockNumber, node.BlockHash, block.Number, block.Hash)
+		m.reorgs.Inc(node.String())
+	}
+}
+
+type blockVotes map[int64]map[string]int
+
+func (v blockVotes) add(number int64, hash string) {
+	if hash == "" {
+		return
+	}
+
+	if v[number] == nil {
+		v[number] = make(map[string]int)
+	}
+	v[number][hash]++
+}
+
+// outvoted reports whether another hash at the same height has more votes
+func (v blockVotes) outvoted(number int64, hash string) bool {
+	for h, count := range v[number] {
+		if h != hash && count > v[number][hash] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// contested reports whether the height has several hashes without a majority
+func (v blockVotes) contested(number int64) bool {
+	best, tie := 0, false
+	for _, count := range v[number] {
+		if count > best {
+			best, tie = count, false
+		} else if count == best {
+			tie = true
+		}
+	}
+
+	return tie && len(v[number]) > 1
+}
+
+// detectForks compares the head and parent hashes reported by the available
+// nodes. Every node votes for its head hash at its height and for its parent
+// hash one block below, a node whose hashes are outvoted is on a minority fork
+// and excluded from selection. Must be called with the pool lock held.
+func detectForks(nodes []Node, m *metrics) {
+	votes := make(blockVotes)
+	for _, n := range nodes {
+		if n.Available {
+			votes.add(n.BlockNumber, n.BlockHash)
+			votes.add(n.BlockNumber-1, n.ParentHash)
+		}
+	}
+
+	for number := range votes {
+		if votes.contested(number) {
+			Warning.Printf("Nodes disagree on block %d without a majority", number)
+		}
+	}
+
+	for i, n := range nodes {
+		forked := n.Available && (votes.outvoted(n.BlockNumber, n.BlockHash) || votes.outvoted(n.BlockNumber-1, n.ParentHash))
+		name := n.String()
+
+		if forked && !n.Forked {
+			Warning.Printf("Node %s is on a minority fork at block %d (%s)", name, n.BlockNumber, n.BlockHash)
+			m.forksDetected.Inc(name)
+		} else if !forked && n.Forked {
+			Info.Printf("Node %s is back on the canonical chain", name)
+		}
+
+		nodes[i].Forked = forked
+		if forked {
+			m.nodeForked.Set(1, name)
+		} else {
+			m.nodeForked.Set(0, name)
+		}
+	}
+}
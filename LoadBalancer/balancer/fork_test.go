@@ -0,0 +1,50 @@
+package balancer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestDetectReorg(t *testing.T) {
+	cases := []struct {
+		name   string
+		change func(n *fakeNode)
+		reorg  bool
+	}{
+		{"new hash at the same height", func(n *fakeNode) { n.setFork(100) }, true},
+		{"next block on another parent", func(n *fakeNode) { n.setFork(100); n.setBlock(101) }, true},
+		{"lower block", func(n *fakeNode) { n.setBlock(99) }, true},
+		{"next block", func(n *fakeNode) { n.setBlock(101) }, false},
+		{"same block", func(n *fakeNode) {}, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			a := newFakeNode(100)
+			defer a.Close()
+
+			pool := newTestPool(t, []*fakeNode{a}, nil)
+			observeTest(pool)
+
+			var log bytes.Buffer
+			Warning.SetOutput(&log)
+			c.change(a)
+			observeTest(pool)
+			Warning.SetOutput(ioutil.Discard)
+
+			name := pool.Nodes[0].String()
+			want := 0.0
+			if c.reorg {
+				want = 1
+			}
+			if count := metricValue(pool.metrics.reorgs, name); count != want {
+				t.Errorf("%v reorgs counted, want %v", count, want)
+			}
+			if logged := strings.Contains(log.String(), "reorganized from block 100"); logged != c.reorg {
+				t.Errorf("reorg warning logged %v, want %v: %s", logged, c.reorg, log.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package balancer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+type rpcCall struct {
+	Id     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+// logsSplit is an eth_getLogs call whose block range is queried in chunks of
+// at most GetLogs.MaxBlockRange blocks
+type logsSplit struct {
+	id     json.RawMessage
+	filter map[string]json.RawMessage
+	from   int64
+	to     int64
+	nodes  []int
+}
+
+// resolveBlockTag returns the number of a filter's fromBlock or toBlock, which
+// default to the latest block. Other tags, e.g. "pending" or "finalized", are
+// not resolved.
+func resolveBlockTag(raw json.RawMessage, head int64) (int64, bool) {
+	var tag string
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &tag); err != nil {
+			return 0, false
+		}
+	}
+
+	switch tag {
+	case "", "latest":
+		return head, true
+	case "earliest":
+		return 0, true
+	}
+
+	number, err := strconv.ParseInt(tag, 0, 64)
+	return number, err == nil && number >= 0
+}
+
+// splitGetLogs returns the split of a single eth_getLogs call whose block range
+// exceeds the pool's maximum, or nil when the call can be proxied as is
+func (p *Pool) splitGetLogs(methods []string, body []byte) *logsSplit {
+	if p.Config.GetLogs.MaxBlockRange == 0 || len(methods) != 1 || methods[0] != "eth_getLogs" {
+		return nil
+	}
+
+	var call rpcCall
+	if err := json.Unmarshal(body, &call); err != nil || len(call.Params) != 1 {
+		return nil
+	}
+
+	var filter map[string]json.RawMessage
+	if err := json.Unmarshal(call.Params[0], &filter); err != nil {
+		return nil
+	}
+	if _, ok := filter["blockHash"]; ok {
+		return nil
+	}
+
+	nodes := p.healthyNodes(len(p.Nodes))
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	// the head tags resolve to the lowest head of the healthy nodes, so any of
+	// them can serve every chunk
+	head := p.lowestHead(nodes)
+
+	from, ok := resolveBlockTag(filter["fromBlock"], head)
+	if !ok {
+		return nil
+	}
+	// a range beyond the head is proxied as is rather than cut short
+	to, ok := resolveBlockTag(filter["toBlock"], head)
+	if !ok || to > head {
+		return nil
+	}
+
+	if from > to || to-from < p.Config.GetLogs.MaxBlockRange {
+		return nil
+	}
+
+	return &logsSplit{id: call.Id, filter: filter, from: from, to: to, nodes: nodes}
+}
+
+// fetchLogs queries the logs of the blocks from..to on the node
+func (p *Pool) fetchLogs(ctx context.Context, split *logsSplit, from int64, to int64, nodeId int) ([]json.RawMessage, error) {
+	filter := make(map[string]interface{}, len(split.filter))
+	for k, v := range split.filter {
+		filter[k] = v
+	}
+	filter["fromBlock"] = fmt.Sprintf("0x%x", from)
+	filter["toBlock"] = fmt.Sprintf("0x%x", to)
+
+	body, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  "eth_getLogs",
+		"params":  []interface{}{filter},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := p.sendTo(ctx, nodeId, body)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Result []json.RawMessage `json:"result"`
+		Error  *JSONRPCError     `json:"error"`
+	}
+	if err := json.Unmarshal(reply, &response); err != nil {
+		return nil, err
+	}
+	if response.Error != nil {
+		return nil, response.Error
+	}
+
+	return response.Result, nil
+}
+
+// serveGetLogs queries the chunks of a split eth_getLogs call on up to
+// GetLogs.Parallel nodes concurrently and answers with their logs merged in
+// block order. A chunk failing on its node is retried once on the next one.
+func (p *Pool) serveGetLogs(w http.ResponseWriter, r *http.Request, split *logsSplit) {
+	size := p.Config.GetLogs.MaxBlockRange
+
+	var chunks [][2]int64
+	for from := split.from; from <= split.to; from += size {
+		to := from + size - 1
+		if to > split.to {
+			to = split.to
+		}
+		chunks = append(chunks, [2]int64{from, to})
+	}
+
+	p.metrics.getLogsSplits.Inc(p.Config.Name)
+
+	workers := p.Config.GetLogs.Parallel
+	if workers > len(split.nodes) {
+		workers = len(split.nodes)
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	results := make([][]json.RawMessage, len(chunks))
+	jobs := make(chan int)
+
+	// the first failure cancels the remaining chunks
+	var failure error
+	var failed sync.Once
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			for i := range jobs {
+				from, to := chunks[i][0], chunks[i][1]
+				// tells the retry of a chunk apart in its upstream spans
+				chunkCtx := withAttemptCounter(ctx)
+
+				logs, err := p.fetchLogs(chunkCtx, split, from, to, split.nodes[w])
+				if _, ok := err.(*JSONRPCError); err != nil && !ok && len(split.nodes) > 1 && ctx.Err() == nil {
+					Warning.Printf("eth_getLogs of blocks %d-%d failed with: %v, retrying", from, to, err)
+					logs, err = p.fetchLogs(chunkCtx, split, from, to, split.nodes[(w+1)%len(split.nodes)])
+				}
+
+				if err != nil {
+					p.metrics.getLogsChunks.Inc(p.Config.Name, "failed")
+					failed.Do(func() {
+						failure = err
+						cancel()
+					})
+					continue
+				}
+
+				p.metrics.getLogsChunks.Inc(p.Config.Name, "ok")
+				results[i] = logs
+			}
+		}(w)
+	}
+
+loop:
+	for i := range chunks {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	if failure == nil {
+		failure = r.Context().Err()
+	}
+
+	if rpcError, ok := failure.(*JSONRPCError); ok {
+		body, _ := r.Context().Value(requestBodyKey{}).([]byte)
+		writeRPCError(w, http.StatusOK, body, rpcError.Code, rpcError.Message)
+		return
+	} else if failure != nil {
+		proxyErrorHandler(w, r, failure)
+		return
+	}
+
+	logs := make([]json.RawMessage, 0)
+	for _, result := range results {
+		logs = append(logs, result...)
+	}
+
+	response, err := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": split.id, "result": logs})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if limit := p.Config.Limits.MaxResponseBytes; limit > 0 && int64(len(response)) > limit {
+		proxyErrorHandler(w, r, errResponseTooLarge)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
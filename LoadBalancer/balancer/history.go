@@ -0,0 +1,292 @@
+package balancer
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultHistoryWindow = 24 * time.Hour
+
+// HistorySample is the state of a node after one observe round
+type HistorySample struct {
+	Time      time.Time `json:"time"`
+	Pool      string    `json:"pool"`
+	Node      string    `json:"node"`
+	Available bool      `json:"available"`
+	Block     int64     `json:"block"`
+	Lag       int64     `json:"lag"`
+}
+
+// HistoryStore keeps node samples in memory and appends them to a JSON lines
+// file, so they survive restarts. Samples older than the retention are
+// dropped and the file is compacted once it holds mostly expired samples.
+type HistoryStore struct {
+	path      string
+	retention time.Duration
+
+	mu          sync.Mutex
+	file        *os.File
+	samples     []HistorySample
+	fileSamples int
+}
+
+func OpenHistory(config HistoryConfig) (*HistoryStore, error) {
+	h := &HistoryStore{
+		path:      config.Path,
+		retention: time.Duration(config.RetentionDays) * 24 * time.Hour,
+	}
+
+	if err := h.load(); err != nil {
+		return nil, err
+	}
+
+	if err := h.compact(); err != nil {
+		return nil, err
+	}
+
+	Info.Printf("Loaded %d history samples from %s", len(h.samples), h.path)
+
+	return h, nil
+}
+
+func (h *HistoryStore) load() error {
+	file, err := os.Open(h.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "Unable to open history")
+	}
+	defer file.Close()
+
+	cutoff := time.Now().Add(-h.retention)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var sample HistorySample
+		if err := json.Unmarshal(scanner.Bytes(), &sample); err != nil {
+			// a crash may leave a truncated last line behind
+			Warning.Printf("Skipping invalid history line: %v", err)
+			continue
+		}
+
+		// histories written before URLs were redacted
+		sample.Node = redactURLString(sample.Node)
+
+		if sample.Time.After(cutoff) {
+			h.samples = append(h.samples, sample)
+		}
+	}
+
+	sort.SliceStable(h.samples, func(i, j int) bool { return h.samples[i].Time.Before(h.samples[j].Time) })
+
+	return errors.Wrap(scanner.Err(), "Unable to read history")
+}
+
+// compact rewrites the file with the retained samples only
+func (h *HistoryStore) compact() error {
+	tmpPath := h.path + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return errors.Wrap(err, "Unable to compact history")
+	}
+
+	w := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(w)
+	for _, sample := range h.samples {
+		if err := encoder.Encode(sample); err != nil {
+			tmp.Close()
+			return errors.Wrap(err, "Unable to compact history")
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "Unable to compact history")
+	}
+	tmp.Close()
+
+	if h.file != nil {
+		h.file.Close()
+	}
+
+	if err := os.Rename(tmpPath, h.path); err != nil {
+		return errors.Wrap(err, "Unable to compact history")
+	}
+
+	h.file, err = os.OpenFile(h.path, os.O_APPEND|os.O_WRONLY, 0644)
+	h.fileSamples = len(h.samples)
+
+	return errors.Wrap(err, "Unable to open history")
+}
+
+func (h *HistoryStore) Record(samples []HistorySample) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	w := bufio.NewWriter(h.file)
+	encoder := json.NewEncoder(w)
+	for _, sample := range samples {
+		if err := encoder.Encode(sample); err != nil {
+			Error.Printf("Writing history failed with: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		Error.Printf("Writing history failed with: %v", err)
+	}
+
+	h.samples = append(h.samples, samples...)
+	h.fileSamples += len(samples)
+
+	cutoff := time.Now().Add(-h.retention)
+	expired := sort.Search(len(h.samples), func(i int) bool { return h.samples[i].Time.After(cutoff) })
+	h.samples = append(h.samples[:0], h.samples[expired:]...)
+
+	if h.fileSamples > 2*len(h.samples) {
+		if err := h.compact(); err != nil {
+			Error.Printf("Compacting history failed with: %v", err)
+		}
+	}
+}
+
+// Query returns the samples since the given time, optionally restricted to a
+// pool and node
+func (h *HistoryStore) Query(since time.Time, pool string, node string) []HistorySample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	start := sort.Search(len(h.samples), func(i int) bool { return !h.samples[i].Time.Before(since) })
+
+	var result []HistorySample
+	for _, sample := range h.samples[start:] {
+		if (pool == "" || sample.Pool == pool) && (node == "" || sample.Node == node) {
+			result = append(result, sample)
+		}
+	}
+
+	return result
+}
+
+func (h *HistoryStore) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.file.Close()
+}
+
+// historySamples returns a sample of every node for the history, nil when it
+// is disabled. Must be called with the pool lock held.
+func (p *Pool) historySamples() []HistorySample {
+	if p.history == nil {
+		return nil
+	}
+
+	now := time.Now()
+	head := headBlock(p.Nodes)
+
+	samples := make([]HistorySample, len(p.Nodes))
+	for i, n := range p.Nodes {
+		samples[i] = HistorySample{
+			Time:      now,
+			Pool:      p.Config.Name,
+			Node:      n.String(),
+			Available: n.Available,
+			Block:     n.BlockNumber,
+		}
+		if n.Available {
+			samples[i].Lag = head - n.BlockNumber
+		}
+	}
+
+	return samples
+}
+
+type lagPoint struct {
+	Time      time.Time `json:"time"`
+	Available bool      `json:"available"`
+	Block     int64     `json:"block"`
+	Lag       int64     `json:"lag"`
+}
+
+type nodeHistory struct {
+	Pool    string     `json:"pool"`
+	Node    string     `json:"node"`
+	Samples int        `json:"samples"`
+	Uptime  float64    `json:"uptime"`
+	AvgLag  float64    `json:"avg_lag"`
+	MaxLag  int64      `json:"max_lag"`
+	History []lagPoint `json:"history"`
+}
+
+// historyHandler reports the uptime percentage and lag history of every node
+// over the window query parameter (a Go duration, 24h by default)
+func historyHandler(history *HistoryStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if history == nil {
+			http.Error(w, "History is not enabled", http.StatusNotFound)
+			return
+		}
+
+		window := defaultHistoryWindow
+		if param := r.URL.Query().Get("window"); param != "" {
+			var err error
+			if window, err = time.ParseDuration(param); err != nil || window <= 0 {
+				http.Error(w, "Invalid window: "+param, http.StatusBadRequest)
+				return
+			}
+		}
+
+		now := time.Now()
+		samples := history.Query(now.Add(-window), r.URL.Query().Get("pool"), r.URL.Query().Get("node"))
+
+		var nodes []*nodeHistory
+		byNode := make(map[[2]string]*nodeHistory)
+		for _, s := range samples {
+			key := [2]string{s.Pool, s.Node}
+			nh, ok := byNode[key]
+			if !ok {
+				nh = &nodeHistory{Pool: s.Pool, Node: s.Node}
+				byNode[key] = nh
+				nodes = append(nodes, nh)
+			}
+
+			nh.Samples++
+			nh.History = append(nh.History, lagPoint{Time: s.Time, Available: s.Available, Block: s.Block, Lag: s.Lag})
+			if s.Available {
+				nh.Uptime++
+				nh.AvgLag += float64(s.Lag)
+				if s.Lag > nh.MaxLag {
+					nh.MaxLag = s.Lag
+				}
+			}
+		}
+
+		for _, nh := range nodes {
+			if nh.Uptime > 0 {
+				nh.AvgLag /= nh.Uptime
+			}
+			nh.Uptime = 100 * nh.Uptime / float64(nh.Samples)
+		}
+
+		js, err := json.MarshalIndent(map[string]interface{}{
+			"from":  now.Add(-window),
+			"to":    now,
+			"nodes": nodes,
+		}, "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(js)
+	}
+}
@@ -0,0 +1,36 @@
+package balancer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestObserveRecordsHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a, b := newFakeNode(100), newFakeNode(90)
+	defer a.Close()
+	defer b.Close()
+
+	store, err := OpenHistory(HistoryConfig{Path: filepath.Join(dir, "history.jsonl"), RetentionDays: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	pool := newTestPool(t, []*fakeNode{a, b}, nil)
+	pool.history = store
+	observeTest(pool)
+
+	samples := store.Query(time.Now().Add(-time.Minute), "", pool.Nodes[1].String())
+	if len(samples) != 1 || !samples[0].Available || samples[0].Lag != 10 {
+		t.Errorf("samples of the lagging node = %+v, want one available with lag 10", samples)
+	}
+}
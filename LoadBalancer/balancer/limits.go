@@ -0,0 +1,116 @@
+package balancer
+
+import (
+	"bytes"
+	"context"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	rpcErrorInvalidRequest = -32600
+	rpcErrorMethodNotFound = -32601
+	rpcErrorServer         = -32000
+)
+
+var (
+	errRequestTooLarge  = errors.New("Request too large")
+	errResponseTooLarge = errors.New("Response too large")
+)
+
+type requestBodyKey struct{}
+
+// timeout returns the upstream timeout of a request calling the given
+// methods, the longest one for batches, or zero for no timeout
+func (c LimitsConfig) timeout(methods []string) time.Duration {
+	timeout := c.Timeout
+	for _, m := range methods {
+		if t, ok := c.MethodTimeouts[m]; ok && t > timeout {
+			timeout = t
+		}
+	}
+
+	return time.Duration(timeout) * time.Second
+}
+
+// readLimited reads at most limit bytes, zero meaning no limit, and fails with
+// tooLarge when there is more
+func readLimited(r io.Reader, limit int64, tooLarge error) ([]byte, error) {
+	if limit <= 0 {
+		return ioutil.ReadAll(r)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(body)) > limit {
+		return nil, tooLarge
+	}
+
+	return body, nil
+}
+
+// limitResponse buffers upstream responses up to the pool's maximum response
+// size, so an oversized one can still be replaced by a clean error
+func limitResponse(resp *http.Response) error {
+	target, ok := resp.Request.Context().Value(upstreamKey{}).(upstream)
+	if !ok {
+		return nil
+	}
+
+	limit := target.pool.Config.Limits.MaxResponseBytes
+	if limit <= 0 {
+		return nil
+	}
+
+	if resp.ContentLength > limit {
+		resp.Body.Close()
+		return errResponseTooLarge
+	}
+
+	body, err := readLimited(resp.Body, limit, errResponseTooLarge)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+
+	return nil
+}
+
+// proxyErrorHandler answers failed proxied requests with JSON-RPC errors
+func proxyErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	// exceeded limits are counted to the pool of the upstream
+	exceeded := func(limit string) {}
+	if target, ok := r.Context().Value(upstreamKey{}).(upstream); ok {
+		exceeded = func(limit string) {
+			target.pool.metrics.limitsExceeded.Inc(target.pool.Config.Name, limit)
+		}
+	}
+
+	body, _ := r.Context().Value(requestBodyKey{}).([]byte)
+
+	switch {
+	case err == errResponseTooLarge:
+		exceeded("response_size")
+		writeRPCError(w, http.StatusBadGateway, body, rpcErrorServer, "Response exceeds the size limit")
+	case errors.Cause(err) == context.DeadlineExceeded || r.Context().Err() == context.DeadlineExceeded:
+		exceeded("timeout")
+		writeRPCError(w, http.StatusGatewayTimeout, body, rpcErrorServer, "Upstream timeout")
+	case err == errLocalRateLimit:
+		exceeded("rate_limit")
+		writeRPCError(w, http.StatusTooManyRequests, body, rpcErrorServer, "Rate limit of the node exceeded")
+	case r.Context().Err() == context.Canceled:
+		// the client went away, nobody to answer
+	default:
+		Error.Printf("Proxying failed with: %v", redactError(err))
+		writeRPCError(w, http.StatusBadGateway, body, rpcErrorServer, "Upstream unavailable")
+	}
+}
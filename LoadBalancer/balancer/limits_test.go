@@ -0,0 +1,81 @@
+package balancer
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimitsTimeout(t *testing.T) {
+	limits := LimitsConfig{Timeout: 2, MethodTimeouts: map[string]int{"eth_getLogs": 10, "eth_call": 1}}
+
+	for _, c := range []struct {
+		methods []string
+		want    time.Duration
+	}{
+		{nil, 2 * time.Second},
+		{[]string{"eth_call"}, 2 * time.Second},
+		{[]string{"eth_getLogs"}, 10 * time.Second},
+		{[]string{"eth_call", "eth_getLogs"}, 10 * time.Second},
+	} {
+		if timeout := limits.timeout(c.methods); timeout != c.want {
+			t.Errorf("timeout of %v = %s, want %s", c.methods, timeout, c.want)
+		}
+	}
+}
+
+func TestProxyLimits(t *testing.T) {
+	cases := []struct {
+		name    string
+		limits  LimitsConfig
+		latency time.Duration
+		status  int
+		message string
+		limit   string
+	}{
+		{"response size", LimitsConfig{MaxResponseBytes: 16}, 0,
+			http.StatusBadGateway, "Response exceeds the size limit", "response_size"},
+		{"method timeout", LimitsConfig{MethodTimeouts: map[string]int{"eth_getBalance": 1}}, 1200 * time.Millisecond,
+			http.StatusGatewayTimeout, "Upstream timeout", "timeout"},
+		{"timeout of another method", LimitsConfig{MethodTimeouts: map[string]int{"eth_call": 1}}, 1200 * time.Millisecond,
+			http.StatusOK, "", ""},
+		{"within the limits", LimitsConfig{MaxResponseBytes: 1024, MethodTimeouts: map[string]int{"eth_getBalance": 1}}, 0,
+			http.StatusOK, "", ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			a := newFakeNode(100)
+			defer a.Close()
+
+			name := strings.Replace(c.name, " ", "-", -1)
+			pool := newTestPool(t, []*fakeNode{a}, func(p *PoolConfig) {
+				p.Name, p.Limits, p.ConnectionTimeout = name, c.limits, 5
+			})
+			observeTest(pool)
+
+			proxy := newTestProxy(pool)
+			defer proxy.Close()
+
+			a.setLatency(c.latency)
+			status, reply := postRPC(t, proxy.URL, getBalance)
+			if status != c.status {
+				t.Errorf("status = %d, want %d", status, c.status)
+			}
+
+			if c.message == "" {
+				if reply.Error != nil {
+					t.Errorf("error = %v, want none", reply.Error)
+				}
+				return
+			}
+			if reply.Error == nil || reply.Error.Message != c.message || reply.Id != 1 {
+				t.Errorf("reply %+v, want the error %q for id 1", reply, c.message)
+			}
+			if exceeded := metricValue(pool.metrics.limitsExceeded, name, c.limit); exceeded != 1 {
+				t.Errorf("%v %s limits exceeded, want 1", exceeded, c.limit)
+			}
+		})
+	}
+}
@@ -1,8 +1,9 @@
-package main
+package balancer
 
 import (
 	"io"
 	"log"
+	"os"
 )
 
 var (
@@ -11,6 +12,12 @@ var (
 	Error   *log.Logger
 )
 
+// the loggers write to stdout and stderr unless the embedding program calls
+// InitLogger
+func init() {
+	InitLogger(os.Stdout, os.Stdout, os.Stderr)
+}
+
 func InitLogger(infoHandle io.Writer, warningHandle io.Writer, errorHandle io.Writer) {
 	Info = log.New(infoHandle, "INFO: ", log.Ldate|log.Ltime)
 
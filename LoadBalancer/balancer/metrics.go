@@ -0,0 +1,211 @@
+package balancer
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MetricVec is a counter or gauge family exposed in the Prometheus text format
+// on /metrics
+type MetricVec struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newMetricVec(kind string, name string, help string, labels []string) *MetricVec {
+	return &MetricVec{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		values: make(map[string]float64),
+	}
+}
+
+// metrics are the counters and gauges of a balancer, shared by its pools
+type metrics struct {
+	// registered in the order they are exposed
+	registered []*MetricVec
+
+	circuitState       *MetricVec
+	circuitOpened      *MetricVec
+	chaosFaults        *MetricVec
+	nodeFinalizedBlock *MetricVec
+	nodeSafeBlock      *MetricVec
+	nodeFinalizedLag   *MetricVec
+	nodeForked         *MetricVec
+	forksDetected      *MetricVec
+	reorgs             *MetricVec
+	getLogsSplits      *MetricVec
+	getLogsChunks      *MetricVec
+	limitsExceeded     *MetricVec
+	tierSeconds        *MetricVec
+	currentTier        *MetricVec
+	rateLimited        *MetricVec
+	rateLimitWaits     *MetricVec
+	quorumRequests     *MetricVec
+	shadowRequests     *MetricVec
+	shadowMismatches   *MetricVec
+	shadowErrors       *MetricVec
+	headAge            *MetricVec
+	poolStale          *MetricVec
+	clientRequests     *MetricVec
+	clientUnits        *MetricVec
+}
+
+func newMetrics() *metrics {
+	m := &metrics{}
+
+	m.circuitState = m.gauge("loadbalancer_circuit_state",
+		"Circuit breaker state per node: 0 closed, 1 half-open, 2 open", "node")
+	m.circuitOpened = m.counter("loadbalancer_circuit_opened_total",
+		"Number of times the circuit of a node was opened", "node")
+
+	m.chaosFaults = m.counter("loadbalancer_chaos_faults_total",
+		"Faults injected into proxied requests by the chaos mode", "pool", "fault")
+
+	m.nodeFinalizedBlock = m.gauge("loadbalancer_node_finalized_block",
+		"Finalized head of the node, 0 when unknown", "node")
+	m.nodeSafeBlock = m.gauge("loadbalancer_node_safe_block",
+		"Safe head of the node, 0 when unknown", "node")
+	m.nodeFinalizedLag = m.gauge("loadbalancer_node_finalized_lag",
+		"Blocks the finalized head of the node is behind the best finalized head of its pool", "node")
+
+	m.nodeForked = m.gauge("loadbalancer_node_forked",
+		"Whether the node is on a minority fork", "node")
+	m.forksDetected = m.counter("loadbalancer_forks_detected_total",
+		"Number of times a node was found on a minority fork", "node")
+	m.reorgs = m.counter("loadbalancer_reorgs_total",
+		"Number of head changes of a node that did not extend its previous head", "node")
+
+	m.getLogsSplits = m.counter("loadbalancer_get_logs_splits_total",
+		"eth_getLogs requests split into chunks", "pool")
+	m.getLogsChunks = m.counter("loadbalancer_get_logs_chunks_total",
+		"Chunks of split eth_getLogs requests by outcome: ok or failed", "pool", "outcome")
+
+	m.limitsExceeded = m.counter("loadbalancer_limits_exceeded_total",
+		"Requests rejected for exceeding a limit: request_size, response_size, timeout or rate_limit of a node", "pool", "limit")
+
+	m.tierSeconds = m.counter("loadbalancer_tier_seconds_total",
+		"Seconds the current node of a pool belonged to each tier", "pool", "tier")
+	m.currentTier = m.gauge("loadbalancer_current_tier",
+		"Tier of the current node of a pool, -1 when no node is available", "pool")
+
+	m.rateLimited = m.counter("loadbalancer_node_rate_limited_total",
+		"Responses of a node signalling its rate limit, which back off the node instead of failing it", "node")
+	m.rateLimitWaits = m.counter("loadbalancer_node_rate_limit_wait_seconds_total",
+		"Seconds requests waited for the configured rate limit of a node", "node")
+
+	m.quorumRequests = m.counter("loadbalancer_quorum_requests_total",
+		"Requests answered by quorum reads by outcome: agreed, disagreed or unavailable", "pool", "method", "outcome")
+
+	m.shadowRequests = m.counter("loadbalancer_shadow_requests_total",
+		"Requests mirrored to the shadow node", "pool", "method")
+	m.shadowMismatches = m.counter("loadbalancer_shadow_mismatches_total",
+		"Mirrored requests whose shadow response differed from the primary one", "pool", "method")
+	m.shadowErrors = m.counter("loadbalancer_shadow_errors_total",
+		"Mirrored requests the shadow node failed to answer", "pool", "method")
+
+	m.headAge = m.gauge("loadbalancer_head_age_seconds",
+		"Age of the newest head block of a pool by its timestamp", "pool")
+	m.poolStale = m.gauge("loadbalancer_pool_stale",
+		"Whether the head of a pool is older than max_head_age, e.g. the whole network stalled", "pool")
+
+	m.clientRequests = m.counter("loadbalancer_client_requests_total",
+		"JSON-RPC calls per named client and method, batches count every call", "pool", "client", "method")
+	m.clientUnits = m.counter("loadbalancer_client_compute_units_total",
+		"Compute units spent per named client, calls weighted by usage.weights", "pool", "client")
+
+	return m
+}
+
+func (m *metrics) counter(name string, help string, labels ...string) *MetricVec {
+	v := newMetricVec("counter", name, help, labels)
+	m.registered = append(m.registered, v)
+
+	return v
+}
+
+func (m *metrics) gauge(name string, help string, labels ...string) *MetricVec {
+	v := newMetricVec("gauge", name, help, labels)
+	m.registered = append(m.registered, v)
+
+	return v
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (m *MetricVec) key(labelValues []string) string {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s expects %d labels, got %d", m.name, len(m.labels), len(labelValues)))
+	}
+
+	pairs := make([]string, len(m.labels))
+	for i, l := range m.labels {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, l, labelEscaper.Replace(labelValues[i]))
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+func (m *MetricVec) Add(value float64, labelValues ...string) {
+	key := m.key(labelValues)
+
+	m.mu.Lock()
+	m.values[key] += value
+	m.mu.Unlock()
+}
+
+func (m *MetricVec) Inc(labelValues ...string) {
+	m.Add(1, labelValues...)
+}
+
+func (m *MetricVec) Set(value float64, labelValues ...string) {
+	key := m.key(labelValues)
+
+	m.mu.Lock()
+	m.values[key] = value
+	m.mu.Unlock()
+}
+
+func (m *MetricVec) write(w *strings.Builder) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+
+	keys := make([]string, 0, len(m.values))
+	for k := range m.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if k == "" {
+			fmt.Fprintf(w, "%s %v\n", m.name, m.values[k])
+		} else {
+			fmt.Fprintf(w, "%s{%s} %v\n", m.name, k, m.values[k])
+		}
+	}
+}
+
+// metricsHandler exposes the metrics in the Prometheus text format
+func metricsHandler(m *metrics) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var out strings.Builder
+		for _, v := range m.registered {
+			v.write(&out)
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write([]byte(out.String()))
+	}
+}
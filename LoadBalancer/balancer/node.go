@@ -0,0 +1,84 @@
+package balancer
+
+import (
+	"github.com/pkg/errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type Node struct {
+	Url         url.URL
+	BlockNumber int64
+	BlockHash   string
+	BlockTime   int64
+	ParentHash  string
+	Forked      bool
+	Available   bool
+	RPCCounter  int
+	Latency     time.Duration
+	Tier        int
+	ChainId     int64
+	Circuit     *CircuitBreaker
+	// proxied requests served
+	Requests int64
+	// finalized and safe heads, probed with finality.probe
+	FinalizedBlock int64
+	SafeBlock      int64
+	// Provider is the type of the node, rate limited nodes back off until
+	// BackoffUntil
+	Provider     string
+	BackoffUntil time.Time
+
+	// name is String of the node, computed once as proxied requests read it
+	// without the pool lock
+	name      string
+	client    *http.Client
+	transport *http.Transport
+	recent    []recentSample
+	provider  *nodeProvider
+}
+
+// observeLatency folds a probe or request duration into the node's
+// exponentially weighted moving average latency
+func (n *Node) observeLatency(latency time.Duration, alpha float64) {
+	if n.Latency == 0 {
+		n.Latency = latency
+		return
+	}
+
+	n.Latency = time.Duration(alpha*float64(latency) + (1-alpha)*float64(n.Latency))
+}
+
+func initNodes(config PoolConfig, m *metrics) ([]Node, error) {
+	nodes := make([]Node, len(config.Nodes))
+
+	for i, n := range config.Nodes {
+		url, err := url.Parse(n.Url)
+		if err != nil {
+			return nil, errors.Wrapf(redactError(err), "Invalid URL of node %d", i)
+		}
+
+		transport := newTransport(config)
+		provider := newNodeProvider(n, m)
+		name := Node{Url: *url, provider: provider}.String()
+		nodes[i] = Node{
+			Url:         *url,
+			Provider:    provider.name,
+			BlockNumber: 0,
+			Available:   false,
+			RPCCounter:  0,
+			Tier:        n.Tier,
+			Circuit:     newCircuitBreaker(name, config.CircuitBreaker, m),
+			client: &http.Client{
+				Transport: transport,
+				Timeout:   time.Duration(config.ConnectionTimeout) * time.Second,
+			},
+			name:      name,
+			transport: transport,
+			provider:  provider,
+		}
+	}
+
+	return nodes, nil
+}
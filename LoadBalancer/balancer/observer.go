@@ -0,0 +1,331 @@
+package balancer
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// observeNode probes a node without holding the pool lock and then applies the
+// result to it
+func (p *Pool) observeNode(ctx context.Context, nodeId int) {
+	config := p.Config
+
+	p.mu.RLock()
+	probe := p.Nodes[nodeId]
+	p.mu.RUnlock()
+
+	if probe.backingOff() {
+		// probes would count against the exhausted rate limit too
+		return
+	}
+
+	ctx, span := p.tracer.StartSpan(ctx, "probe eth_getBlockByNumber", SpanKindClient)
+	defer span.Finish()
+	span.SetAttribute("rpc.system", "jsonrpc")
+	span.SetAttribute("rpc.method", "eth_getBlockByNumber")
+	span.SetAttribute("server.address", probe.Url.Host)
+
+	Info.Printf("Observing node: %s", probe)
+	start := time.Now()
+	err := checkChainId(ctx, &probe, config)
+	var block Block
+	if err == nil {
+		block, err = getBlock(ctx, &probe, "latest")
+	}
+	latency := time.Since(start)
+
+	var finalized, safe int64
+	if err == nil && config.Finality.Probe {
+		finalized, safe = probeFinality(ctx, &probe)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	node := &p.Nodes[nodeId]
+	node.RPCCounter = probe.RPCCounter
+	node.ChainId = probe.ChainId
+
+	if limited, ok := err.(*rateLimitError); ok {
+		// the node is fine, the provider just asks to slow down
+		span.SetError(err)
+		node.backOff(limited)
+		return
+	}
+	node.Circuit.Record(err == nil)
+
+	if err != nil {
+		Error.Printf("Obsserving %s failed with: %v", node, err)
+		span.SetError(err)
+		node.Available = false
+		// check the chain again once the node is back
+		node.ChainId = 0
+	} else {
+		detectReorg(*node, block, p.metrics)
+
+		node.Available = true
+		node.BlockNumber = block.Number
+		node.BlockHash = block.Hash
+		node.ParentHash = block.ParentHash
+		node.BlockTime = block.Timestamp
+		if config.Finality.Probe {
+			node.FinalizedBlock, node.SafeBlock = finalized, safe
+			p.metrics.nodeFinalizedBlock.Set(float64(finalized), node.String())
+			p.metrics.nodeSafeBlock.Set(float64(safe), node.String())
+		}
+		node.observeLatency(latency, config.LatencyAlpha)
+		span.SetAttribute("lb.block_number", block.Number)
+		span.SetAttribute("lb.block_hash", block.Hash)
+		Info.Printf("Observing result of %s: block %d (%s), latency %v", node, node.BlockNumber, node.BlockHash, node.Latency)
+	}
+}
+
+// checkChainId makes sure a node serves the pool's chain, when one is
+// configured. The chain id is only requested until it is known.
+func checkChainId(ctx context.Context, node *Node, config PoolConfig) error {
+	if config.ChainId == 0 || node.ChainId != 0 {
+		return nil
+	}
+
+	chainId, err := getChainId(ctx, node)
+	if err != nil {
+		return err
+	}
+
+	if chainId != config.ChainId {
+		return errors.Errorf("Node %s is on chain %d instead of %d", node, chainId, config.ChainId)
+	}
+
+	node.ChainId = chainId
+	return nil
+}
+
+func headBlock(nodes []Node) (maxBlock int64) {
+	for _, n := range nodes {
+		if n.Available && !n.Forked && n.BlockNumber > maxBlock {
+			maxBlock = n.BlockNumber
+		}
+	}
+
+	return maxBlock
+}
+
+func inSync(node Node, maxBlock int64, maxFinalized int64, config PoolConfig) bool {
+	return node.Available && !node.Forked && node.Circuit.State() != CircuitOpen && maxBlock-node.BlockNumber <= config.BlockThreshold &&
+		finalityInSync(node, maxFinalized, config)
+}
+
+// selectable reports whether new traffic may be routed to the node. Nodes with
+// a half-open circuit or backing off from a rate limit are only selectable when
+// no node has a closed one, nodes on a minority fork never are.
+func selectable(node Node, allowHalfOpen bool) bool {
+	if node.Forked {
+		return false
+	}
+	if node.backingOff() {
+		return node.Available && allowHalfOpen
+	}
+
+	switch node.Circuit.State() {
+	case CircuitClosed:
+		return node.Available
+	case CircuitHalfOpen:
+		return node.Available && allowHalfOpen
+	}
+
+	return false
+}
+
+// chooseBestNodeId picks a node from the most preferred tier that has an in
+// sync node, so backup tiers are only used when every node of the lower tiers
+// is down or lagging. It returns -1 when no node is available.
+func chooseBestNodeId(nodes []Node, config PoolConfig) (bestNodeId int) {
+	maxBlock, maxFinalized := selectionHeads(nodes, config)
+
+	allowHalfOpen := true
+	for _, n := range nodes {
+		if n.Available && n.Circuit.State() == CircuitClosed && !n.backingOff() {
+			allowHalfOpen = false
+			break
+		}
+	}
+
+	tier := -1
+	for _, n := range nodes {
+		if selectable(n, allowHalfOpen) && inSync(n, maxBlock, maxFinalized, config) && (tier < 0 || n.Tier < tier) {
+			tier = n.Tier
+		}
+	}
+
+	bestNodeId = -1
+	for i, n := range nodes {
+		if selectable(n, allowHalfOpen) && n.Tier == tier && finalityInSync(n, maxFinalized, config) &&
+			(bestNodeId < 0 || n.BlockNumber > nodes[bestNodeId].BlockNumber) {
+			bestNodeId = i
+		}
+	}
+
+	if bestNodeId < 0 || config.Selection != SelectionLatency {
+		return bestNodeId
+	}
+
+	// prefer the fastest node among those close enough to the head
+	for i, n := range nodes {
+		if selectable(n, allowHalfOpen) && n.Tier == tier && maxBlock-n.BlockNumber <= config.LatencyTolerance &&
+			finalityInSync(n, maxFinalized, config) && n.Latency < nodes[bestNodeId].Latency {
+			bestNodeId = i
+		}
+	}
+
+	return bestNodeId
+}
+
+// latencySwitchMargin is how much faster than the current node another one
+// must be for latency selection to switch to it, so that nodes of similar
+// latency don't take turns every round
+const latencySwitchMargin = 0.2
+
+// latencySwitch reports whether latency selection leaves the current node for
+// the best one
+func latencySwitch(current Node, best Node, maxBlock int64, config PoolConfig) bool {
+	if maxBlock-current.BlockNumber > config.LatencyTolerance {
+		return true
+	}
+
+	return float64(best.Latency) < (1-latencySwitchMargin)*float64(current.Latency)
+}
+
+// accountTier adds the time since the previous observe round to the tier that
+// was serving during it. Must be called with the pool lock held.
+func (p *Pool) accountTier(tier int) {
+	now := time.Now()
+	if p.lastTier >= 0 {
+		p.metrics.tierSeconds.Add(now.Sub(p.lastTierSince).Seconds(), p.Config.Name, strconv.Itoa(p.lastTier))
+	}
+
+	if tier > 0 && tier != p.lastTier {
+		Warning.Printf("Pool %s is serving from backup tier %d", p.Config.Name, tier)
+	}
+
+	p.lastTier, p.lastTierSince = tier, now
+	p.metrics.currentTier.Set(float64(tier), p.Config.Name)
+}
+
+// probeNodes observes all nodes concurrently with at most ProbeWorkers probes
+// in flight, each bounded by ConnectionTimeout
+func (p *Pool) probeNodes(ctx context.Context) {
+	jobs := make(chan int)
+	timeout := time.Duration(p.Config.ConnectionTimeout) * time.Second
+
+	workers := p.Config.ProbeWorkers
+	if workers > len(p.Nodes) {
+		workers = len(p.Nodes)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range jobs {
+				probeCtx, cancel := context.WithTimeout(ctx, timeout)
+				p.observeNode(probeCtx, i)
+				cancel()
+			}
+		}()
+	}
+
+loop:
+	for i := range p.Nodes {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+
+	close(jobs)
+	wg.Wait()
+}
+
+func (p *Pool) observe(ctx context.Context) {
+	ctx, span := p.tracer.StartSpan(ctx, "observe", SpanKindInternal)
+	defer span.Finish()
+	span.SetAttribute("lb.pool", p.Config.Name)
+
+	p.mu.RLock()
+	prev, prevCurrent := append([]Node(nil), p.Nodes...), p.CurrentNodeId
+	p.mu.RUnlock()
+
+	p.probeNodes(ctx)
+	if ctx.Err() != nil {
+		return
+	}
+
+	// the history file is written once the lock is released, so slow disks do
+	// not hold up proxied requests
+	var samples []HistorySample
+	defer func() {
+		if len(samples) > 0 {
+			p.history.Record(samples)
+		}
+	}()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	config, nodes := p.Config, p.Nodes
+
+	detectForks(nodes, p.metrics)
+	p.accountFinality()
+	p.checkStaleness()
+
+	bestNodeId := chooseBestNodeId(nodes, config)
+
+	if p.CurrentNodeId < 0 {
+		p.CurrentNodeId = bestNodeId
+	} else if bestNodeId >= 0 {
+		currentNode := nodes[p.CurrentNodeId]
+		bestNode := nodes[bestNodeId]
+
+		maxBlock, maxFinalized := selectionHeads(nodes, config)
+		if !inSync(currentNode, maxBlock, maxFinalized, config) || currentNode.Tier > bestNode.Tier || currentNode.backingOff() ||
+			(config.Selection == SelectionLatency && latencySwitch(currentNode, bestNode, maxBlock, config)) {
+			p.CurrentNodeId = bestNodeId
+		}
+	} else if !selectable(nodes[p.CurrentNodeId], true) {
+		p.CurrentNodeId = -1
+	}
+
+	p.publishTransitions(prev, prevCurrent)
+	samples = p.historySamples()
+	p.recordRecent()
+
+	if p.CurrentNodeId < 0 {
+		p.accountTier(-1)
+		Error.Printf("No available nodes in pool %s", config.Name)
+		return
+	}
+
+	p.accountTier(nodes[p.CurrentNodeId].Tier)
+	span.SetAttribute("lb.current_node", nodes[p.CurrentNodeId].Url.Host)
+	Info.Printf("Best node of pool %s: %s", config.Name, nodes[p.CurrentNodeId])
+}
+
+func (p *Pool) startPeriodicObserve(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(p.Config.Interval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.observe(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package balancer
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestObserveSelectsHighestBlock(t *testing.T) {
+	a, b := newFakeNode(100), newFakeNode(105)
+	defer a.Close()
+	defer b.Close()
+
+	pool := newTestPool(t, []*fakeNode{a, b}, nil)
+
+	if current := observeTest(pool); current != 1 {
+		t.Fatalf("current node = %d, want 1", current)
+	}
+	if block := pool.Nodes[1].BlockNumber; block != 105 {
+		t.Errorf("block of node 1 = %d, want 105", block)
+	}
+	if !pool.Nodes[0].Available || !pool.Nodes[1].Available {
+		t.Errorf("nodes not available after observe: %v, %v", pool.Nodes[0].Available, pool.Nodes[1].Available)
+	}
+}
+
+func TestObserveKeepsCurrentWithinThreshold(t *testing.T) {
+	a, b := newFakeNode(100), newFakeNode(100)
+	defer a.Close()
+	defer b.Close()
+
+	pool := newTestPool(t, []*fakeNode{a, b}, func(c *PoolConfig) { c.BlockThreshold = 3 })
+
+	current := observeTest(pool)
+	if current < 0 {
+		t.Fatal("no current node")
+	}
+
+	other := []*fakeNode{a, b}[1-current]
+	other.setBlock(103)
+	if next := observeTest(pool); next != current {
+		t.Errorf("current node = %d, want %d while lagging by the threshold", next, current)
+	}
+}
+
+func TestObserveFailsOverOnLag(t *testing.T) {
+	a, b := newFakeNode(100), newFakeNode(99)
+	defer a.Close()
+	defer b.Close()
+
+	pool := newTestPool(t, []*fakeNode{a, b}, func(c *PoolConfig) { c.BlockThreshold = 3 })
+
+	if current := observeTest(pool); current != 0 {
+		t.Fatalf("current node = %d, want 0", current)
+	}
+
+	b.setBlock(104)
+	if current := observeTest(pool); current != 1 {
+		t.Errorf("current node = %d, want 1 after node 0 lags by 4 blocks", current)
+	}
+}
+
+func TestObserveFailsOverOnUnavailability(t *testing.T) {
+	for name, fail := range map[string]func(*fakeNode){
+		"http error": func(n *fakeNode) { n.setStatus(http.StatusBadGateway) },
+		"rpc error":  func(n *fakeNode) { n.setRPCError(&JSONRPCError{Code: -32000, Message: "header not found"}) },
+		"down":       func(n *fakeNode) { n.Close() },
+	} {
+		t.Run(name, func(t *testing.T) {
+			a, b := newFakeNode(100), newFakeNode(100)
+			defer a.Close()
+			defer b.Close()
+
+			pool := newTestPool(t, []*fakeNode{a, b}, nil)
+
+			current := observeTest(pool)
+			if current < 0 {
+				t.Fatal("no current node")
+			}
+
+			fail([]*fakeNode{a, b}[current])
+			if next := observeTest(pool); next != 1-current {
+				t.Errorf("current node = %d, want %d", next, 1-current)
+			}
+			if pool.Nodes[current].Available {
+				t.Error("failed node still available")
+			}
+		})
+	}
+}
+
+func TestObserveNoAvailableNodes(t *testing.T) {
+	a := newFakeNode(100)
+	defer a.Close()
+
+	pool := newTestPool(t, []*fakeNode{a}, nil)
+
+	a.setStatus(http.StatusServiceUnavailable)
+	if current := observeTest(pool); current != -1 {
+		t.Fatalf("current node = %d, want -1 before any node was available", current)
+	}
+
+	a.setStatus(0)
+	if current := observeTest(pool); current != 0 {
+		t.Fatalf("current node = %d, want 0 after recovery", current)
+	}
+
+	a.setStatus(http.StatusServiceUnavailable)
+	if current := observeTest(pool); current != -1 {
+		t.Errorf("current node = %d, want -1 after the only node failed", current)
+	}
+}
+
+func TestObserveTimeout(t *testing.T) {
+	a, b := newFakeNode(100), newFakeNode(100)
+	defer a.Close()
+	defer b.Close()
+
+	pool := newTestPool(t, []*fakeNode{a, b}, nil)
+
+	current := observeTest(pool)
+	if current < 0 {
+		t.Fatal("no current node")
+	}
+
+	// slower than ConnectionTimeout
+	[]*fakeNode{a, b}[current].setLatency(1500 * time.Millisecond)
+	if next := observeTest(pool); next != 1-current {
+		t.Errorf("current node = %d, want %d after a probe timed out", next, 1-current)
+	}
+}
+
+func TestObserveChainIdMismatch(t *testing.T) {
+	a, b := newFakeNode(100), newFakeNode(200)
+	defer a.Close()
+	defer b.Close()
+
+	b.setChainId(5)
+	pool := newTestPool(t, []*fakeNode{a, b}, func(c *PoolConfig) { c.ChainId = 1337 })
+
+	if current := observeTest(pool); current != 0 {
+		t.Errorf("current node = %d, want 0", current)
+	}
+	if pool.Nodes[1].Available {
+		t.Error("node of another chain is available")
+	}
+
+	observeTest(pool)
+	if calls := a.calls("eth_chainId"); calls != 1 {
+		t.Errorf("eth_chainId called %d times on a matching node, want 1", calls)
+	}
+}
+
+func TestObserveExcludesForkedNode(t *testing.T) {
+	a, b, c := newFakeNode(100), newFakeNode(100), newFakeNode(101)
+	defer a.Close()
+	defer b.Close()
+	defer c.Close()
+
+	c.setFork(100)
+	pool := newTestPool(t, []*fakeNode{a, b, c}, nil)
+
+	current := observeTest(pool)
+	if current == 2 || current < 0 {
+		t.Errorf("current node = %d, want a node of the canonical chain", current)
+	}
+	if !pool.Nodes[2].Forked {
+		t.Error("node on the minority fork not detected")
+	}
+}
+
+func TestObservePrefersLowerTier(t *testing.T) {
+	a, b := newFakeNode(100), newFakeNode(101)
+	defer a.Close()
+	defer b.Close()
+
+	pool := newTestPool(t, []*fakeNode{a, b}, func(c *PoolConfig) {
+		c.BlockThreshold = 2
+		c.Nodes[1].Tier = 1
+	})
+
+	if current := observeTest(pool); current != 0 {
+		t.Fatalf("current node = %d, want the tier 0 node", current)
+	}
+
+	a.setStatus(http.StatusBadGateway)
+	if current := observeTest(pool); current != 1 {
+		t.Errorf("current node = %d, want the backup node", current)
+	}
+
+	a.setStatus(0)
+	if current := observeTest(pool); current != 0 {
+		t.Errorf("current node = %d, want the tier 0 node back", current)
+	}
+}
+
+func TestObserveFinality(t *testing.T) {
+	a, b := newFakeNode(1000), newFakeNode(1000)
+	defer a.Close()
+	defer b.Close()
+
+	a.setFinalized(900)
+	b.setFinalized(968)
+	pool := newTestPool(t, []*fakeNode{a, b}, func(c *PoolConfig) { c.Finality.MaxLag = 32 })
+
+	if current := observeTest(pool); current != 1 {
+		t.Fatalf("current node = %d, want the node with the best finalized head", current)
+	}
+	if n := pool.Nodes[0]; n.FinalizedBlock != 900 || n.SafeBlock != 950 {
+		t.Errorf("finalized %d, safe %d, want 900 and 950", n.FinalizedBlock, n.SafeBlock)
+	}
+
+	b.setStatus(http.StatusBadGateway)
+	if current := observeTest(pool); current != 0 {
+		t.Errorf("current node = %d, want the only available node", current)
+	}
+}
+
+func TestObserveFinalityPreMerge(t *testing.T) {
+	a, b := newFakeNode(1000), newFakeNode(1001)
+	defer a.Close()
+	defer b.Close()
+
+	b.setFinalized(-1)
+	pool := newTestPool(t, []*fakeNode{a, b}, func(c *PoolConfig) { c.Finality.MaxLag = 100 })
+
+	if current := observeTest(pool); current != 0 {
+		t.Errorf("current node = %d, want the node reporting its finalized head", current)
+	}
+	if !pool.Nodes[1].Available || pool.Nodes[1].FinalizedBlock != 0 {
+		t.Errorf("pre-merge node available %v, finalized %d, want available without finalized head",
+			pool.Nodes[1].Available, pool.Nodes[1].FinalizedBlock)
+	}
+}
+
+func TestObserveLatencySelection(t *testing.T) {
+	cases := []struct {
+		name     string
+		latency  time.Duration
+		block    int64
+		switches bool
+	}{
+		{"slightly faster", 90 * time.Millisecond, 100, false},
+		{"much faster", 20 * time.Millisecond, 100, true},
+		{"much faster out of tolerance", 20 * time.Millisecond, 97, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			a, b := newFakeNode(100), newFakeNode(100)
+			defer a.Close()
+			defer b.Close()
+
+			pool := newTestPool(t, []*fakeNode{a, b}, func(c *PoolConfig) {
+				c.Selection, c.LatencyTolerance, c.LatencyAlpha = SelectionLatency, 2, 1
+			})
+			a.setLatency(100 * time.Millisecond)
+			b.setLatency(200 * time.Millisecond)
+			if current := observeTest(pool); current != 0 {
+				t.Fatalf("current = %d, want the faster node 0", current)
+			}
+
+			b.setLatency(c.latency)
+			b.setBlock(c.block)
+			want := 0
+			if c.switches {
+				want = 1
+			}
+			for i := 0; i < 3; i++ {
+				if current := observeTest(pool); current != want {
+					t.Fatalf("current = %d in round %d, want %d", current, i, want)
+				}
+			}
+		})
+	}
+}
+
+func TestObserveLatencySelectionLeavesLaggingNode(t *testing.T) {
+	a, b := newFakeNode(100), newFakeNode(100)
+	defer a.Close()
+	defer b.Close()
+
+	pool := newTestPool(t, []*fakeNode{a, b}, func(c *PoolConfig) {
+		c.Selection, c.LatencyTolerance, c.BlockThreshold, c.LatencyAlpha = SelectionLatency, 2, 10, 1
+	})
+	b.setLatency(50 * time.Millisecond)
+	if current := observeTest(pool); current != 0 {
+		t.Fatalf("current = %d, want the faster node 0", current)
+	}
+
+	// within block_threshold, but out of latency_tolerance
+	a.setBlock(100)
+	b.setBlock(105)
+	if current := observeTest(pool); current != 1 {
+		t.Errorf("current = %d, want 1 once node 0 is out of latency_tolerance", current)
+	}
+}
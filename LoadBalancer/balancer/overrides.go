@@ -0,0 +1,215 @@
+package balancer
+
+import (
+	"flag"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"reflect"
+	"strings"
+)
+
+const (
+	envPrefix = "LB_"
+	// envConfig lists the config files, comma separated, when no -config flag
+	// is given
+	envConfig = envPrefix + "CONFIG"
+
+	defaultConfigPath = "config.yml"
+)
+
+// configKey is a setting which can be overridden by an environment variable
+// and a flag, e.g. circuit_breaker.failure_ratio by
+// LB_CIRCUIT_BREAKER_FAILURE_RATIO and -circuit_breaker.failure_ratio
+type configKey struct {
+	path []string
+	// lists can also be given comma separated
+	list bool
+}
+
+func (k configKey) name() string {
+	return strings.Join(k.path, ".")
+}
+
+func (k configKey) env() string {
+	return envPrefix + strings.ToUpper(strings.Join(k.path, "_"))
+}
+
+// configKeys returns every setting of Config by its yaml keys, except those in
+// lists of mappings like pools, which only config files can set
+func configKeys() []configKey {
+	return appendConfigKeys(nil, reflect.TypeOf(Config{}), nil)
+}
+
+func appendConfigKeys(keys []configKey, t reflect.Type, path []string) []configKey {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		name, inline := yamlKey(field)
+		if inline {
+			keys = appendConfigKeys(keys, field.Type, path)
+			continue
+		} else if name == "" {
+			continue
+		}
+
+		fieldPath := append(append([]string(nil), path...), name)
+
+		switch field.Type.Kind() {
+		case reflect.Struct:
+			keys = appendConfigKeys(keys, field.Type, fieldPath)
+		case reflect.Slice:
+			// nodes are lists of URLs, see NodeConfig.UnmarshalYAML
+			elem := field.Type.Elem()
+			if elem.Kind() != reflect.Struct || elem == reflect.TypeOf(NodeConfig{}) {
+				keys = append(keys, configKey{path: fieldPath, list: true})
+			}
+		default:
+			keys = append(keys, configKey{path: fieldPath})
+		}
+	}
+
+	return keys
+}
+
+// envOverrides returns the settings given by LB_* variables of environ, keyed
+// by setting name
+func envOverrides(environ []string) map[string]string {
+	env := make(map[string]string)
+	for _, e := range environ {
+		if i := strings.Index(e, "="); i > 0 && e[i+1:] != "" {
+			env[e[:i]] = e[i+1:]
+		}
+	}
+
+	overrides := make(map[string]string)
+	for _, key := range configKeys() {
+		if value, ok := env[key.env()]; ok {
+			overrides[key.name()] = value
+		}
+	}
+
+	return overrides
+}
+
+// applyOverrides sets the overridden settings in a decoded yaml document.
+// Values are yaml, so lists and mappings can be given in flow style, e.g.
+// "{Authorization: Bearer xxx}".
+func applyOverrides(tree map[interface{}]interface{}, overrides map[string]string) error {
+	for _, key := range configKeys() {
+		raw, ok := overrides[key.name()]
+		if !ok {
+			continue
+		}
+
+		var value interface{}
+		if key.list && !strings.HasPrefix(strings.TrimSpace(raw), "[") {
+			var items []interface{}
+			for _, item := range strings.Split(raw, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			value = items
+		} else if err := yaml.Unmarshal([]byte(raw), &value); err != nil {
+			return errors.Wrapf(err, "Invalid value of %s (%s)", key.name(), key.env())
+		}
+
+		// decoding the value alone tells which variable or flag is wrong
+		single := make(map[interface{}]interface{})
+		setPath(single, key.path, value)
+		if doc, err := yaml.Marshal(single); err != nil || yaml.UnmarshalStrict(doc, &Config{}) != nil {
+			return errors.Errorf("Invalid value of %s (%s): %q", key.name(), key.env(), raw)
+		}
+
+		setPath(tree, key.path, value)
+	}
+
+	return nil
+}
+
+func setPath(tree map[interface{}]interface{}, path []string, value interface{}) {
+	node := tree
+	for _, name := range path[:len(path)-1] {
+		child, ok := node[name].(map[interface{}]interface{})
+		if !ok {
+			child = make(map[interface{}]interface{})
+			node[name] = child
+		}
+		node = child
+	}
+	node[path[len(path)-1]] = value
+}
+
+// mergeTree merges the yaml document src into dst: mappings are merged key by
+// key, any other value of src, lists included, replaces the one of dst
+func mergeTree(dst map[interface{}]interface{}, src map[interface{}]interface{}) {
+	for k, v := range src {
+		srcMap, srcOk := v.(map[interface{}]interface{})
+		dstMap, dstOk := dst[k].(map[interface{}]interface{})
+		if srcOk && dstOk {
+			mergeTree(dstMap, srcMap)
+		} else {
+			dst[k] = v
+		}
+	}
+}
+
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+type overrideFlag struct {
+	key       configKey
+	overrides map[string]string
+}
+
+func (f *overrideFlag) String() string {
+	return ""
+}
+
+func (f *overrideFlag) Set(value string) error {
+	f.overrides[f.key.name()] = value
+	return nil
+}
+
+// ConfigFlags are the -config flag and a flag per setting overriding it
+type ConfigFlags struct {
+	paths     stringList
+	overrides map[string]string
+}
+
+// NewConfigFlags defines the config flags on the flag set
+func NewConfigFlags(flags *flag.FlagSet) *ConfigFlags {
+	f := &ConfigFlags{overrides: make(map[string]string)}
+
+	flags.Var(&f.paths, "config", "Path to configuration file, repeat to merge overlays into it (default "+defaultConfigPath+")")
+	for _, key := range configKeys() {
+		flags.Var(&overrideFlag{key: key, overrides: f.overrides}, key.name(), "Overrides "+key.name()+", like "+key.env())
+	}
+
+	return f
+}
+
+// Load reads the config files, from the flags and paths, LB_CONFIG or the
+// default path, and applies the overrides, flags taking precedence over
+// environ
+func (f *ConfigFlags) Load(environ []string, paths ...string) (Config, error) {
+	paths = append(append([]string(nil), f.paths...), paths...)
+	if len(paths) == 0 {
+		paths = []string{defaultConfigPath}
+		for _, e := range environ {
+			if strings.HasPrefix(e, envConfig+"=") && e != envConfig+"=" {
+				paths = strings.Split(strings.TrimPrefix(e, envConfig+"="), ",")
+			}
+		}
+	}
+
+	return LoadConfig(paths, envOverrides(environ), f.overrides)
+}
@@ -0,0 +1,104 @@
+package balancer
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+)
+
+const baseConfig = `port: 8000
+check_interval: 30
+block_threshold: 10
+nodes: [http://localhost:8545, http://localhost:8546]
+circuit_breaker:
+  failure_ratio: 0.5
+  window: 20
+`
+
+func TestOverlayMerge(t *testing.T) {
+	paths, remove := writeConfigs(t, baseConfig, "nodes: [http://localhost:8547]\ncircuit_breaker:\n  window: 50\n")
+	defer remove()
+
+	config, err := LoadConfig(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// lists are replaced, mappings merged key by key
+	if len(config.Nodes) != 1 || config.Nodes[0].Url != "http://localhost:8547" {
+		t.Errorf("nodes %+v, want the overlay ones", config.Nodes)
+	}
+	if cb := config.CircuitBreaker; cb.FailureRatio != 0.5 || cb.Window != 50 {
+		t.Errorf("circuit_breaker %+v, want failure_ratio of the base and window of the overlay", cb)
+	}
+	if config.Port != 8000 || config.Interval != 30 {
+		t.Errorf("port %d, check_interval %d, want the base 8000 and 30", config.Port, config.Interval)
+	}
+}
+
+func TestOverridePrecedence(t *testing.T) {
+	cases := []struct {
+		name    string
+		environ []string
+		args    []string
+		want    int64
+	}{
+		{"file", nil, nil, 10},
+		{"env", []string{"LB_BLOCK_THRESHOLD=20"}, nil, 20},
+		{"flag", nil, []string{"-block_threshold=30"}, 30},
+		{"flag over env", []string{"LB_BLOCK_THRESHOLD=20"}, []string{"-block_threshold", "30"}, 30},
+		{"empty env", []string{"LB_BLOCK_THRESHOLD="}, nil, 10},
+	}
+
+	paths, remove := writeConfigs(t, baseConfig)
+	defer remove()
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			flags := flag.NewFlagSet("test", flag.ContinueOnError)
+			configFlags := NewConfigFlags(flags)
+			if err := flags.Parse(append([]string{"-config", paths[0]}, c.args...)); err != nil {
+				t.Fatal(err)
+			}
+
+			config, err := configFlags.Load(c.environ)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.BlockThreshold != c.want {
+				t.Errorf("block_threshold %d, want %d", config.BlockThreshold, c.want)
+			}
+		})
+	}
+}
+
+func TestOverrideValues(t *testing.T) {
+	paths, remove := writeConfigs(t, baseConfig)
+	defer remove()
+
+	environ := []string{
+		"LB_CONFIG=" + paths[0],
+		"LB_NODES=http://localhost:8547, http://localhost:8548",
+		"LB_CIRCUIT_BREAKER_WINDOW=40",
+	}
+	config, err := NewConfigFlags(flag.NewFlagSet("test", flag.ContinueOnError)).Load(environ)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var urls []string
+	for _, n := range config.Nodes {
+		urls = append(urls, n.Url)
+	}
+	if want := []string{"http://localhost:8547", "http://localhost:8548"}; !reflect.DeepEqual(urls, want) {
+		t.Errorf("nodes %v, want %v", urls, want)
+	}
+	if cb := config.CircuitBreaker; cb.FailureRatio != 0.5 || cb.Window != 40 {
+		t.Errorf("circuit_breaker %+v, want failure_ratio of the file and window of the environ", cb)
+	}
+
+	environ[2] = "LB_CIRCUIT_BREAKER_WINDOW=many"
+	if _, err := NewConfigFlags(flag.NewFlagSet("test", flag.ContinueOnError)).Load(environ); err == nil {
+		t.Error("no error for an invalid override")
+	}
+}
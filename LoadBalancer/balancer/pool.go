@@ -0,0 +1,134 @@
+package balancer
+
+import (
+	"github.com/pkg/errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// shared is the state the pools of a balancer have in common. The stores and
+// the tracer are nil when disabled.
+type shared struct {
+	events  *EventHub
+	history *HistoryStore
+	usage   *UsageStore
+	tracer  *Tracer
+	metrics *metrics
+}
+
+// Pool is an independent set of nodes of one chain with its own observer and
+// current node
+type Pool struct {
+	Config PoolConfig
+	Nodes  []Node
+	// -1 until the first available node is found
+	CurrentNodeId int
+	// the newest head is older than max_head_age
+	Stale bool
+
+	shadow *Shadow
+	chaos  *Chaos
+	*shared
+
+	// mu guards Nodes, CurrentNodeId and Stale, which are read by the proxy while the
+	// observer and the proxied requests update them
+	mu sync.RWMutex
+
+	lastTier      int
+	lastTierSince time.Time
+}
+
+// NewPool returns a pool publishing to its own event hub and metrics, without
+// history, usage accounting and tracing
+func NewPool(config PoolConfig) (*Pool, error) {
+	return newPool(config, &shared{events: NewEventHub(), metrics: newMetrics()})
+}
+
+func newPool(config PoolConfig, s *shared) (*Pool, error) {
+	nodes, err := initNodes(config, s.metrics)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Pool %s", config.Name)
+	}
+
+	return &Pool{
+		Config:        config,
+		Nodes:         nodes,
+		CurrentNodeId: -1,
+		shadow:        newShadow(config, s.metrics),
+		chaos:         newChaos(config, s.metrics),
+		shared:        s,
+		lastTier:      -1,
+	}, nil
+}
+
+// current returns the id of the node requests are proxied to, or -1
+func (p *Pool) current() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.CurrentNodeId
+}
+
+func (p *Pool) isDefault() bool {
+	return p.Config.PathPrefix == "" && p.Config.Host == ""
+}
+
+// matchPath reports whether the path is below the pool's path prefix and
+// returns the remaining path to forward
+func (p *Pool) matchPath(path string) (string, bool) {
+	prefix := strings.TrimSuffix(p.Config.PathPrefix, "/")
+	if prefix == "" {
+		return path, true
+	}
+
+	if path == prefix || strings.HasPrefix(path, prefix+"/") {
+		return strings.TrimPrefix(path, prefix), true
+	}
+
+	return "", false
+}
+
+func (p *Pool) matchHost(host string) bool {
+	if p.Config.Host == "" {
+		return true
+	}
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	return strings.EqualFold(host, p.Config.Host)
+}
+
+// selectPool routes a request to the pool whose host and path prefix match,
+// preferring pools bound to a host and then the longest path prefix, so the
+// default pool only serves requests no other pool matches. It returns the
+// path with the pool's prefix stripped.
+func selectPool(pools []*Pool, r *http.Request) (*Pool, string) {
+	var (
+		best      *Pool
+		bestPath  string
+		bestScore = -1
+	)
+
+	for _, p := range pools {
+		path, ok := p.matchPath(r.URL.Path)
+		if !ok || !p.matchHost(r.Host) {
+			continue
+		}
+
+		score := len(strings.TrimSuffix(p.Config.PathPrefix, "/"))
+		if p.Config.Host != "" {
+			score += 1 << 16
+		}
+
+		if score > bestScore {
+			best, bestPath, bestScore = p, path, score
+		}
+	}
+
+	return best, bestPath
+}
@@ -0,0 +1,293 @@
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ProviderGeneric = "generic"
+	ProviderGeth    = "geth"
+	ProviderBesu    = "besu"
+	ProviderInfura  = "infura"
+	ProviderAlchemy = "alchemy"
+
+	// proxied requests waiting longer for the rate limit of their node are
+	// rejected
+	maxRateLimitWait = time.Second
+
+	defaultBackoff = 10 * time.Second
+	maxBackoff     = 5 * time.Minute
+)
+
+// Provider describes how a kind of node is accessed
+type Provider struct {
+	// keyPath is the path the API key is appended to, e.g. /v3 of Infura.
+	// Without it the API key is sent as bearer token.
+	keyPath string
+	// unsupported lists the methods, or method prefixes ending in _, the
+	// provider does not serve
+	unsupported []string
+	// rateLimit is the default limit in requests per second, zero for none
+	rateLimit float64
+	// rateLimitCodes are the JSON-RPC error codes of rate limited calls, which
+	// are answered with HTTP 429 too
+	rateLimitCodes []int
+}
+
+// providerMethods are not served by hosted providers, which neither manage
+// accounts nor expose node administration
+var providerMethods = []string{
+	"admin_", "miner_", "personal_", "eth_accounts", "eth_coinbase", "eth_sendTransaction", "eth_sign",
+	"eth_signTransaction",
+}
+
+var providers = map[string]Provider{
+	ProviderGeneric: {},
+	// Geth has no Parity style tracing, debug_trace* covers it
+	ProviderGeth: {
+		unsupported: []string{"trace_", "parity_"},
+	},
+	// Besu names its transaction pool methods txpool_besu*
+	ProviderBesu: {
+		unsupported: []string{"txpool_content", "txpool_inspect", "txpool_status"},
+	},
+	ProviderInfura: {
+		keyPath:        "/v3",
+		unsupported:    append([]string{"debug_", "txpool_"}, providerMethods...),
+		rateLimit:      10,
+		rateLimitCodes: []int{-32005},
+	},
+	ProviderAlchemy: {
+		keyPath:        "/v2",
+		unsupported:    providerMethods,
+		rateLimit:      25,
+		rateLimitCodes: []int{429},
+	},
+}
+
+func (p Provider) supports(method string) bool {
+	for _, m := range p.unsupported {
+		if method == m || (strings.HasSuffix(m, "_") && strings.HasPrefix(method, m)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// rateLimitError is a call rejected by the provider for exceeding its rate
+// limit, not a sign of an unhealthy node
+type rateLimitError struct {
+	retryAfter time.Duration
+	cause      error
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("Rate limited: %v", e.cause)
+}
+
+var errLocalRateLimit = errors.New("Rate limit of the node exceeded")
+
+// nodeProvider applies the provider of a node with the node's credentials
+// and rate limit
+type nodeProvider struct {
+	name     string
+	provider Provider
+	apiKey   string
+	metrics  *metrics
+
+	mu sync.Mutex
+	// interval between requests, zero without rate limit
+	interval time.Duration
+	// next is when the next request may be sent
+	next time.Time
+}
+
+func newNodeProvider(config NodeConfig, m *metrics) *nodeProvider {
+	name := config.Type
+	if name == "" {
+		name = ProviderGeneric
+	}
+
+	p := &nodeProvider{name: name, provider: providers[name], apiKey: config.ApiKey, metrics: m}
+
+	rateLimit := p.provider.rateLimit
+	if config.RateLimit != 0 {
+		rateLimit = config.RateLimit
+	}
+	if rateLimit > 0 {
+		p.interval = time.Duration(float64(time.Second) / rateLimit)
+	}
+
+	return p
+}
+
+// endpoint returns the URL requests to the node are sent to, with the API key
+// in the path for providers expecting it there
+func (p *nodeProvider) endpoint(u url.URL) url.URL {
+	return p.withKey(u, p.apiKey)
+}
+
+func (p *nodeProvider) withKey(u url.URL, key string) url.URL {
+	if key == "" || p.provider.keyPath == "" {
+		return u
+	}
+
+	path := strings.TrimSuffix(u.Path, "/")
+	if !strings.HasSuffix(path, p.provider.keyPath) {
+		path += p.provider.keyPath
+	}
+	u.Path = path + "/" + url.PathEscape(key)
+	u.RawPath = ""
+
+	return u
+}
+
+// authorize adds the bearer token of providers not taking the API key in the
+// path
+func (p *nodeProvider) authorize(req *http.Request) {
+	if p.apiKey != "" && p.provider.keyPath == "" {
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+}
+
+// wait delays a request until the rate limit of the node allows it. Requests
+// which would have to wait longer than maxWait are rejected right away.
+func (p *nodeProvider) wait(ctx context.Context, node string, maxWait time.Duration) error {
+	if p.interval == 0 {
+		return nil
+	}
+
+	p.mu.Lock()
+	now := time.Now()
+	if p.next.Before(now) {
+		p.next = now
+	}
+	delay := p.next.Sub(now)
+	if maxWait > 0 && delay > maxWait {
+		p.mu.Unlock()
+		return errLocalRateLimit
+	}
+	p.next = p.next.Add(p.interval)
+	p.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	p.metrics.rateLimitWaits.Add(delay.Seconds(), node)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// rateLimitedResponse interprets an HTTP 429 response
+func rateLimitedResponse(resp *http.Response) *rateLimitError {
+	if resp.StatusCode != http.StatusTooManyRequests {
+		return nil
+	}
+
+	return &rateLimitError{
+		retryAfter: retryAfter(resp.Header.Get("Retry-After")),
+		cause:      errors.Errorf("Invalid response status: %d", resp.StatusCode),
+	}
+}
+
+// rateLimitedCall interprets a JSON-RPC error of a call
+func (p *nodeProvider) rateLimitedCall(err *JSONRPCError) *rateLimitError {
+	for _, code := range p.provider.rateLimitCodes {
+		if err.Code == code {
+			return &rateLimitError{cause: err}
+		}
+	}
+
+	return nil
+}
+
+// retryAfter parses the seconds of a Retry-After header, zero if missing
+func retryAfter(header string) time.Duration {
+	seconds, err := strconv.Atoi(strings.TrimSpace(header))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// backOff keeps the node out of selection, while leaving it available, for
+// the time the provider asked for or defaultBackoff. Must be called with the
+// pool lock held.
+func (n *Node) backOff(err *rateLimitError) {
+	backoff := err.retryAfter
+	if backoff <= 0 {
+		backoff = defaultBackoff
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+
+	until := time.Now().Add(backoff)
+	if until.After(n.BackoffUntil) {
+		Warning.Printf("Node %s is rate limited, backing off for %v", n, backoff)
+		n.BackoffUntil = until
+	}
+	n.provider.metrics.rateLimited.Inc(n.String())
+}
+
+func (n Node) backingOff() bool {
+	return time.Now().Before(n.BackoffUntil)
+}
+
+// supports reports whether the node serves all the methods
+func (n Node) supports(methods []string) bool {
+	for _, method := range methods {
+		if !n.provider.provider.supports(method) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// nodeFor returns the node serving the methods, the current one unless its
+// provider does not serve them or it backs off from a rate limit, or -1 when
+// no in sync node serves them. A backing off current node is kept when no
+// other one is in sync.
+func (p *Pool) nodeFor(methods []string, current int) int {
+	p.mu.RLock()
+	node := p.Nodes[current]
+	p.mu.RUnlock()
+	if node.supports(methods) && !node.backingOff() {
+		return current
+	}
+
+	// healthy nodes are not backing off
+	for _, id := range p.healthyNodes(len(p.Nodes)) {
+		p.mu.RLock()
+		supported := p.Nodes[id].supports(methods)
+		p.mu.RUnlock()
+		if supported {
+			return id
+		}
+	}
+
+	if node.supports(methods) {
+		return current
+	}
+
+	return -1
+}
@@ -0,0 +1,215 @@
+package balancer
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestProviderKeyInPath(t *testing.T) {
+	a := newFakeNode(100)
+	defer a.Close()
+
+	pool := newTestPool(t, []*fakeNode{a}, func(c *PoolConfig) {
+		c.Nodes[0].Type, c.Nodes[0].ApiKey = ProviderInfura, "secretkey"
+	})
+	if current := observeTest(pool); current != 0 {
+		t.Fatalf("current = %d, want 0", current)
+	}
+
+	if path, authorization := a.lastRequest(); path != "/v3/secretkey" || authorization != "" {
+		t.Errorf("request to %q with authorization %q, want /v3/secretkey without", path, authorization)
+	}
+	if name := pool.Nodes[0].String(); strings.Contains(name, "secretkey") {
+		t.Errorf("node name %s contains the API key", name)
+	}
+}
+
+func TestProviderBearerToken(t *testing.T) {
+	a := newFakeNode(100)
+	defer a.Close()
+
+	pool := newTestPool(t, []*fakeNode{a}, func(c *PoolConfig) {
+		c.Nodes[0].Type, c.Nodes[0].ApiKey = ProviderBesu, "token"
+	})
+	observeTest(pool)
+	if _, authorization := a.lastRequest(); authorization != "Bearer token" {
+		t.Errorf("probe authorization = %q, want Bearer token", authorization)
+	}
+
+	proxy := newTestProxy(pool)
+	defer proxy.Close()
+
+	a.setBlock(101)
+	if status, _ := postRPC(t, proxy.URL, getBalance); status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	if _, authorization := a.lastRequest(); authorization != "Bearer token" {
+		t.Errorf("proxied authorization = %q, want Bearer token", authorization)
+	}
+}
+
+func TestRateLimitedNodeBacksOff(t *testing.T) {
+	cases := map[string]func(n *fakeNode){
+		"http 429":   func(n *fakeNode) { n.setStatus(http.StatusTooManyRequests) },
+		"infura rpc": func(n *fakeNode) { n.setRPCError(&JSONRPCError{Code: -32005, Message: "daily request count exceeded"}) },
+	}
+
+	for name, limit := range cases {
+		t.Run(name, func(t *testing.T) {
+			a, b := newFakeNode(100), newFakeNode(100)
+			defer a.Close()
+			defer b.Close()
+
+			pool := newTestPool(t, []*fakeNode{a, b}, func(c *PoolConfig) {
+				c.Nodes[0].Type, c.Nodes[1].Type = ProviderInfura, ProviderInfura
+			})
+			current := observeTest(pool)
+			if current < 0 {
+				t.Fatal("no current node")
+			}
+
+			limit([]*fakeNode{a, b}[current])
+			if next := observeTest(pool); next != 1-current {
+				t.Errorf("current = %d after the rate limit, want %d", next, 1-current)
+			}
+
+			pool.mu.RLock()
+			node := pool.Nodes[current]
+			pool.mu.RUnlock()
+			if !node.Available || !node.backingOff() || node.Circuit.State() != CircuitClosed {
+				t.Errorf("rate limited node available %v, backing off %v, circuit %v, want available and backing off with a closed circuit",
+					node.Available, node.backingOff(), node.Circuit.State())
+			}
+		})
+	}
+}
+
+func TestUnsupportedMethodRouted(t *testing.T) {
+	a, b := newFakeNode(100), newFakeNode(99)
+	defer a.Close()
+	defer b.Close()
+
+	pool := newTestPool(t, []*fakeNode{a, b}, func(c *PoolConfig) {
+		c.Nodes[0].Type = ProviderInfura
+		c.BlockThreshold = 5
+	})
+	if current := observeTest(pool); current != 0 {
+		t.Fatalf("current = %d, want 0", current)
+	}
+
+	proxy := newTestProxy(pool)
+	defer proxy.Close()
+
+	postRPC(t, proxy.URL, `{"jsonrpc":"2.0","id":1,"method":"debug_traceTransaction","params":["0x0"]}`)
+	if a.calls("debug_traceTransaction") != 0 || b.calls("debug_traceTransaction") != 1 {
+		t.Errorf("debug_traceTransaction calls = %d and %d, want 0 and 1",
+			a.calls("debug_traceTransaction"), b.calls("debug_traceTransaction"))
+	}
+
+	b.setStatus(http.StatusInternalServerError)
+	observeTest(pool)
+
+	_, reply := postRPC(t, proxy.URL, `{"jsonrpc":"2.0","id":1,"method":"debug_traceTransaction","params":["0x0"]}`)
+	if reply.Error == nil || reply.Error.Code != rpcErrorMethodNotFound {
+		t.Errorf("error = %v, want method not found", reply.Error)
+	}
+}
+
+func TestLocalRateLimit(t *testing.T) {
+	a := newFakeNode(100)
+	defer a.Close()
+
+	// a request every two seconds, the probe takes the first slot
+	pool := newTestPool(t, []*fakeNode{a}, func(c *PoolConfig) { c.Nodes[0].RateLimit = 0.5 })
+	observeTest(pool)
+
+	proxy := newTestProxy(pool)
+	defer proxy.Close()
+
+	status, reply := postRPC(t, proxy.URL, getBalance)
+	if status != http.StatusTooManyRequests || reply.Error == nil {
+		t.Errorf("status = %d, error %v, want 429 with an error", status, reply.Error)
+	}
+	if calls := a.calls("eth_getBalance"); calls != 0 {
+		t.Errorf("eth_getBalance calls = %d, want 0", calls)
+	}
+}
+
+func TestProxiedRateLimitSwitchesNode(t *testing.T) {
+	a, b := newFakeNode(100), newFakeNode(99)
+	defer a.Close()
+	defer b.Close()
+
+	pool := newTestPool(t, []*fakeNode{a, b}, func(c *PoolConfig) { c.BlockThreshold = 5 })
+	if current := observeTest(pool); current != 0 {
+		t.Fatalf("current = %d, want 0", current)
+	}
+
+	proxy := newTestProxy(pool)
+	defer proxy.Close()
+
+	a.setStatus(http.StatusTooManyRequests)
+	if status, _ := postRPC(t, proxy.URL, getBalance); status != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", status)
+	}
+
+	// before the next observe round
+	for i := 0; i < 3; i++ {
+		_, reply := postRPC(t, proxy.URL, getBalance)
+		if url := servedBy(t, reply); url != b.server.URL {
+			t.Fatalf("served by %s while the current node backs off, want %s", url, b.server.URL)
+		}
+	}
+}
+
+func TestClientMethodsRouted(t *testing.T) {
+	a, b := newFakeNode(100), newFakeNode(100)
+	defer a.Close()
+	defer b.Close()
+
+	pool := newTestPool(t, []*fakeNode{a, b}, func(c *PoolConfig) {
+		c.Nodes[0].Type, c.Nodes[1].Type = ProviderGeth, ProviderBesu
+	})
+	observeTest(pool)
+
+	proxy := newTestProxy(pool)
+	defer proxy.Close()
+
+	postRPC(t, proxy.URL, `{"jsonrpc":"2.0","id":1,"method":"trace_block","params":["0x1"]}`)
+	postRPC(t, proxy.URL, `{"jsonrpc":"2.0","id":2,"method":"txpool_content","params":[]}`)
+
+	if a.calls("trace_block") != 0 || b.calls("trace_block") != 1 {
+		t.Errorf("trace_block calls = %d and %d, want besu only", a.calls("trace_block"), b.calls("trace_block"))
+	}
+	if a.calls("txpool_content") != 1 || b.calls("txpool_content") != 0 {
+		t.Errorf("txpool_content calls = %d and %d, want geth only", a.calls("txpool_content"), b.calls("txpool_content"))
+	}
+}
+
+func TestRateLimitedProxyWhileObserving(t *testing.T) {
+	a := newFakeNode(100)
+	defer a.Close()
+
+	pool := newTestPool(t, []*fakeNode{a}, func(c *PoolConfig) { c.Nodes[0].RateLimit = 10000 })
+	observeTest(pool)
+
+	proxy := newTestProxy(pool)
+	defer proxy.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			a.setBlock(int64(101 + i))
+			observeTest(pool)
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		if status, _ := postRPC(t, proxy.URL, getBalance); status != http.StatusOK {
+			t.Errorf("status = %d, want 200", status)
+		}
+	}
+	<-done
+}
@@ -0,0 +1,249 @@
+package balancer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"time"
+)
+
+type upstreamKey struct{}
+
+// upstream is the pool and node a proxied request was routed to
+type upstream struct {
+	pool   *Pool
+	nodeId int
+}
+
+// nodeTransport sends proxied requests over the connection pool of the node
+// they are routed to, feeds the outcome into the circuit breaker and the
+// duration of successful ones into the latency average of the node. Requests
+// not routed to a node go through base.
+type nodeTransport struct {
+	base http.RoundTripper
+}
+
+func (t *nodeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	target, ok := req.Context().Value(upstreamKey{}).(upstream)
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+
+	pool := target.pool
+	provider := pool.Nodes[target.nodeId].provider
+
+	provider.authorize(req)
+	if err := provider.wait(req.Context(), pool.Nodes[target.nodeId].name, maxRateLimitWait); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	resp, err := pool.Nodes[target.nodeId].transport.RoundTrip(req)
+	limited := err == nil && resp.StatusCode == http.StatusTooManyRequests
+	success := err == nil && resp.StatusCode < 500
+	// a client going away or a method timeout says nothing about the node
+	if req.Context().Err() == nil {
+		pool.Nodes[target.nodeId].Circuit.Record(success)
+	}
+
+	pool.mu.Lock()
+	node := &pool.Nodes[target.nodeId]
+	node.Requests++
+	if limited {
+		node.backOff(rateLimitedResponse(resp))
+	} else if success {
+		node.observeLatency(time.Since(start), pool.Config.LatencyAlpha)
+	}
+	pool.mu.Unlock()
+
+	return resp, err
+}
+
+type poolInfo struct {
+	Nodes   []Node `json:"nodes"`
+	Current string `json:"current"`
+	Stale   bool   `json:"stale"`
+}
+
+func (p *Pool) info() poolInfo {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	info := poolInfo{Nodes: append([]Node(nil), p.Nodes...), Stale: p.Stale}
+	if p.CurrentNodeId >= 0 {
+		info.Current = p.Nodes[p.CurrentNodeId].String()
+	}
+
+	return info
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// newHandler returns the handler serving the API endpoints from the shared
+// state and proxying all other requests to the pools
+func newHandler(ctx context.Context, s *shared, pools []*Pool) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/info", func(w http.ResponseWriter, r *http.Request) {
+		data := make(map[string]interface{})
+		poolsData := make(map[string]poolInfo)
+		for _, p := range pools {
+			info := p.info()
+			poolsData[p.Config.Name] = info
+
+			if p.isDefault() {
+				data["nodes"] = info.Nodes
+				data["current"] = info.Current
+			}
+		}
+		data["pools"] = poolsData
+
+		js, err := json.MarshalIndent(data, "", "  ")
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(js)
+	})
+
+	proxy := &httputil.ReverseProxy{Director: func(req *http.Request) {
+		target := req.Context().Value(upstreamKey{}).(upstream)
+
+		target.pool.mu.RLock()
+		node := target.pool.Nodes[target.nodeId]
+		target.pool.mu.RUnlock()
+		currentNodeUrl := node.provider.endpoint(node.Url)
+
+		originHost := currentNodeUrl.Host
+		originPathPrefix := currentNodeUrl.Path
+
+		req.Header.Add("X-Forwarded-Host", req.Host)
+		req.Header.Add("X-Origin-Host", originHost)
+		req.Host = originHost
+		req.URL.Scheme = currentNodeUrl.Scheme
+		req.URL.Host = originHost
+		req.URL.Path = originPathPrefix + req.URL.Path
+	},
+		Transport:      &tracingTransport{base: &nodeTransport{base: http.DefaultTransport}},
+		ModifyResponse: limitResponse,
+		ErrorHandler:   proxyErrorHandler,
+	}
+
+	mux.HandleFunc("/metrics", metricsHandler(s.metrics))
+	mux.HandleFunc("/events", eventsHandler(ctx, s.events))
+	mux.HandleFunc("/history", historyHandler(s.history))
+	mux.HandleFunc("/usage", usageHandler(s.usage))
+	mux.HandleFunc("/dashboard", dashboardHandler(pools))
+	mux.HandleFunc("/ready", readyHandler(pools))
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		pool, path := selectPool(pools, r)
+		if pool == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		nodeId := pool.current()
+		if nodeId < 0 {
+			http.Error(w, "No available nodes", http.StatusInternalServerError)
+			return
+		}
+
+		body, err := peekBody(r, pool.Config.Limits.MaxRequestBytes)
+		if err == errRequestTooLarge {
+			pool.metrics.limitsExceeded.Inc(pool.Config.Name, "request_size")
+			writeRPCError(w, http.StatusRequestEntityTooLarge, nil, rpcErrorInvalidRequest, "Request exceeds the size limit")
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		methods := parseRPCMethods(body)
+
+		if nodeId = pool.nodeFor(methods, nodeId); nodeId < 0 {
+			writeRPCError(w, http.StatusOK, body, rpcErrorMethodNotFound, "Method not supported by the nodes")
+			return
+		}
+
+		if pool.usage != nil {
+			pool.usage.Record(pool.Config.Name, r, methods)
+		}
+
+		ctx, span := pool.tracer.StartSpan(extractTraceParent(r.Context(), r.Header), "proxy "+r.Method, SpanKindServer)
+		defer span.Finish()
+
+		span.SetAttribute("http.request.method", r.Method)
+		span.SetAttribute("url.path", r.URL.Path)
+		span.SetAttribute("rpc.system", "jsonrpc")
+		span.SetAttribute("lb.pool", pool.Config.Name)
+		if len(methods) == 1 {
+			span.SetAttribute("rpc.method", methods[0])
+		} else if len(methods) > 1 {
+			span.SetAttribute("rpc.method", "batch")
+			span.SetAttribute("rpc.batch.methods", methods)
+		}
+
+		if timeout := pool.Config.Limits.timeout(methods); timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		ctx = context.WithValue(ctx, upstreamKey{}, upstream{pool: pool, nodeId: nodeId})
+
+		if fault := pool.chaos.pick(methods); fault != nil {
+			span.SetAttribute("lb.chaos.fault", fault.Type)
+
+			pool.mu.RLock()
+			head := pool.Nodes[nodeId].BlockNumber
+			pool.mu.RUnlock()
+
+			var proceed bool
+			if body, proceed = pool.chaos.inject(ctx, w, r, fault, body, head); !proceed {
+				return
+			}
+		}
+
+		ctx = context.WithValue(ctx, requestBodyKey{}, body)
+		r.URL.Path, r.URL.RawPath = path, ""
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		split := pool.splitGetLogs(methods, body)
+
+		switch {
+		case pool.requiresQuorum(methods):
+			pool.serveQuorum(recorder, r.WithContext(ctx), body, methods)
+		case split != nil:
+			pool.serveGetLogs(recorder, r.WithContext(ctx), split)
+		case pool.shadow.sample(methods):
+			mirrored := &bodyRecorder{statusRecorder: recorder}
+			served := make(chan struct{})
+			defer close(served)
+			go pool.shadow.compare(body, methods, mirrored, served)
+			proxy.ServeHTTP(mirrored, r.WithContext(withAttemptCounter(ctx)))
+		default:
+			proxy.ServeHTTP(recorder, r.WithContext(withAttemptCounter(ctx)))
+		}
+
+		span.SetAttribute("http.response.status_code", recorder.status)
+		if recorder.status >= 500 {
+			span.SetError(fmt.Errorf("Invalid response status: %d", recorder.status))
+		}
+	})
+
+	return mux
+}
@@ -0,0 +1,269 @@
+package balancer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestProxy serves the pools like the balancer does, with the shared state
+// of the first one
+func newTestProxy(pools ...*Pool) *httptest.Server {
+	return httptest.NewServer(newHandler(context.Background(), pools[0].shared, pools))
+}
+
+// postRPC sends a JSON-RPC request to the proxy and returns the status and the
+// decoded response, if any
+func postRPC(t *testing.T, url string, body string) (int, JSONRPCResponse) {
+	resp, err := http.Post(url, "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reply JSONRPCResponse
+	json.Unmarshal(data, &reply)
+
+	return resp.StatusCode, reply
+}
+
+const getBalance = `{"jsonrpc":"2.0","id":1,"method":"eth_getBalance","params":["0x0","latest"]}`
+
+// servedBy returns the URL of the fake node that answered eth_getBalance
+func servedBy(t *testing.T, reply JSONRPCResponse) string {
+	var url string
+	if err := json.Unmarshal(reply.Result, &url); err != nil {
+		t.Fatalf("Unexpected reply %s: %v", reply.Result, err)
+	}
+
+	return url
+}
+
+func TestProxyToCurrentNode(t *testing.T) {
+	a, b := newFakeNode(100), newFakeNode(105)
+	defer a.Close()
+	defer b.Close()
+
+	pool := newTestPool(t, []*fakeNode{a, b}, nil)
+	observeTest(pool)
+
+	proxy := newTestProxy(pool)
+	defer proxy.Close()
+
+	status, reply := postRPC(t, proxy.URL, getBalance)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	if url := servedBy(t, reply); url != b.server.URL {
+		t.Errorf("served by %s, want %s", url, b.server.URL)
+	}
+	pool.mu.RLock()
+	requests := pool.Nodes[1].Requests
+	pool.mu.RUnlock()
+	if requests != 1 {
+		t.Errorf("requests of the current node = %d, want 1", requests)
+	}
+}
+
+func TestProxyFailover(t *testing.T) {
+	a, b := newFakeNode(100), newFakeNode(100)
+	defer a.Close()
+	defer b.Close()
+
+	pool := newTestPool(t, []*fakeNode{a, b}, func(c *PoolConfig) { c.BlockThreshold = 1 })
+	proxy := newTestProxy(pool)
+	defer proxy.Close()
+
+	current := observeTest(pool)
+	nodes := []*fakeNode{a, b}
+
+	_, reply := postRPC(t, proxy.URL, getBalance)
+	if url := servedBy(t, reply); url != nodes[current].server.URL {
+		t.Fatalf("served by %s, want %s", url, nodes[current].server.URL)
+	}
+
+	nodes[1-current].setBlock(110)
+	observeTest(pool)
+
+	_, reply = postRPC(t, proxy.URL, getBalance)
+	if url := servedBy(t, reply); url != nodes[1-current].server.URL {
+		t.Errorf("served by %s after failover, want %s", url, nodes[1-current].server.URL)
+	}
+}
+
+func TestProxyNoCurrentNode(t *testing.T) {
+	a := newFakeNode(100)
+	defer a.Close()
+
+	pool := newTestPool(t, []*fakeNode{a}, nil)
+	proxy := newTestProxy(pool)
+	defer proxy.Close()
+
+	// before the first observe round
+	if status, _ := postRPC(t, proxy.URL, getBalance); status != http.StatusInternalServerError {
+		t.Errorf("status before observing = %d, want 500", status)
+	}
+
+	observeTest(pool)
+	if status, _ := postRPC(t, proxy.URL, getBalance); status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+
+	a.setStatus(http.StatusBadGateway)
+	observeTest(pool)
+	if status, _ := postRPC(t, proxy.URL, getBalance); status != http.StatusInternalServerError {
+		t.Errorf("status after the only node failed = %d, want 500", status)
+	}
+	if calls := a.calls("eth_getBalance"); calls != 1 {
+		t.Errorf("eth_getBalance proxied %d times, want only while the node was available", calls)
+	}
+}
+
+func TestProxyRoutesByPathPrefix(t *testing.T) {
+	a, b := newFakeNode(100), newFakeNode(100)
+	defer a.Close()
+	defer b.Close()
+
+	defaultPool := newTestPool(t, []*fakeNode{a}, nil)
+	other := newTestPool(t, []*fakeNode{b}, func(c *PoolConfig) {
+		c.Name = "other"
+		c.PathPrefix = "/other"
+	})
+	observeTest(defaultPool)
+	observeTest(other)
+
+	proxy := newTestProxy(defaultPool, other)
+	defer proxy.Close()
+
+	for path, want := range map[string]string{
+		"/":           a.server.URL,
+		"/other":      b.server.URL,
+		"/other/":     b.server.URL,
+		"/otherwise/": a.server.URL,
+	} {
+		_, reply := postRPC(t, proxy.URL+path, getBalance)
+		if url := servedBy(t, reply); url != want {
+			t.Errorf("%s served by %s, want %s", path, url, want)
+		}
+	}
+}
+
+func TestProxyNoMatchingPool(t *testing.T) {
+	a := newFakeNode(100)
+	defer a.Close()
+
+	pool := newTestPool(t, []*fakeNode{a}, func(c *PoolConfig) { c.PathPrefix = "/eth" })
+	observeTest(pool)
+
+	proxy := newTestProxy(pool)
+	defer proxy.Close()
+
+	if status, _ := postRPC(t, proxy.URL+"/btc", getBalance); status != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", status)
+	}
+}
+
+func TestProxyRequestSizeLimit(t *testing.T) {
+	a := newFakeNode(100)
+	defer a.Close()
+
+	pool := newTestPool(t, []*fakeNode{a}, func(c *PoolConfig) { c.Limits.MaxRequestBytes = 32 })
+	observeTest(pool)
+
+	proxy := newTestProxy(pool)
+	defer proxy.Close()
+
+	status, reply := postRPC(t, proxy.URL, getBalance)
+	if status != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want 413", status)
+	}
+	if reply.Error == nil || reply.Error.Code != rpcErrorInvalidRequest {
+		t.Errorf("error = %v, want an invalid request error", reply.Error)
+	}
+	if calls := a.calls("eth_getBalance"); calls != 0 {
+		t.Errorf("oversized request proxied %d times", calls)
+	}
+}
+
+func TestProxySplitsGetLogs(t *testing.T) {
+	a, b := newFakeNode(100), newFakeNode(100)
+	defer a.Close()
+	defer b.Close()
+
+	pool := newTestPool(t, []*fakeNode{a, b}, func(c *PoolConfig) {
+		c.GetLogs.MaxBlockRange = 5
+		c.GetLogs.Parallel = 2
+	})
+	observeTest(pool)
+
+	proxy := newTestProxy(pool)
+	defer proxy.Close()
+
+	status, reply := postRPC(t, proxy.URL,
+		`{"jsonrpc":"2.0","id":7,"method":"eth_getLogs","params":[{"fromBlock":"0x50","toBlock":"latest"}]}`)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	if reply.Id != 7 {
+		t.Errorf("id = %d, want 7", reply.Id)
+	}
+
+	var logs []struct {
+		BlockNumber string `json:"blockNumber"`
+	}
+	if err := json.Unmarshal(reply.Result, &logs); err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 21 {
+		t.Fatalf("%d logs, want 21 of blocks 80 to 100", len(logs))
+	}
+	for i, l := range logs {
+		if want := fmt.Sprintf("0x%x", 0x50+i); l.BlockNumber != want {
+			t.Errorf("log %d of block %s, want %s", i, l.BlockNumber, want)
+		}
+	}
+
+	if chunks := a.calls("eth_getLogs") + b.calls("eth_getLogs"); chunks != 5 {
+		t.Errorf("%d chunks requested, want 5", chunks)
+	}
+}
+
+func TestSplitGetLogsRange(t *testing.T) {
+	a := newFakeNode(100)
+	defer a.Close()
+
+	pool := newTestPool(t, []*fakeNode{a}, func(c *PoolConfig) { c.GetLogs.MaxBlockRange = 5 })
+	observeTest(pool)
+
+	for filter, want := range map[string][]int64{
+		`{"fromBlock":"0x50","toBlock":"latest"}`:  {0x50, 100},
+		`{"fromBlock":"0x50"}`:                     {0x50, 100},
+		`{"fromBlock":"0x50","toBlock":"0x60"}`:    {0x50, 0x60},
+		`{"fromBlock":"0x50","toBlock":"0x6e"}`:    nil,
+		`{"fromBlock":"0x50","toBlock":"pending"}`: nil,
+		`{"fromBlock":"0x50","toBlock":"0x52"}`:    nil,
+		`{"blockHash":"0x01"}`:                     nil,
+	} {
+		body := `{"jsonrpc":"2.0","id":1,"method":"eth_getLogs","params":[` + filter + `]}`
+		split := pool.splitGetLogs([]string{"eth_getLogs"}, []byte(body))
+
+		switch {
+		case want == nil && split != nil:
+			t.Errorf("%s split into %d-%d, want it proxied as is", filter, split.from, split.to)
+		case want != nil && split == nil:
+			t.Errorf("%s proxied as is, want it split", filter)
+		case want != nil && (split.from != want[0] || split.to != want[1]):
+			t.Errorf("%s split into %d-%d, want %d-%d", filter, split.from, split.to, want[0], want[1])
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package balancer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// upstreamClient sends requests the balancer issues on behalf of a client
+// directly to a node, recording them like proxied requests
+var upstreamClient = &http.Client{Transport: &tracingTransport{base: &nodeTransport{base: http.DefaultTransport}}}
+
+// requiresQuorum reports whether all called methods are configured for quorum
+// reads
+func (p *Pool) requiresQuorum(methods []string) bool {
+	if p.Config.Quorum.Nodes == 0 || len(methods) == 0 {
+		return false
+	}
+
+	for _, m := range methods {
+		found := false
+		for _, q := range p.Config.Quorum.Methods {
+			found = found || q == m
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// healthyNodes returns the ids of up to n healthy nodes, preferring lower tiers
+// and higher blocks
+func (p *Pool) healthyNodes(n int) []int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	maxBlock, maxFinalized := selectionHeads(p.Nodes, p.Config)
+
+	var ids []int
+	for i, node := range p.Nodes {
+		if selectable(node, false) && inSync(node, maxBlock, maxFinalized, p.Config) {
+			ids = append(ids, i)
+		}
+	}
+
+	sort.SliceStable(ids, func(i, j int) bool {
+		a, b := p.Nodes[ids[i]], p.Nodes[ids[j]]
+		if a.Tier != b.Tier {
+			return a.Tier < b.Tier
+		}
+		return a.BlockNumber > b.BlockNumber
+	})
+
+	if len(ids) > n {
+		ids = ids[:n]
+	}
+
+	return ids
+}
+
+type quorumReply struct {
+	body    []byte
+	results map[string]interface{}
+	err     error
+}
+
+func (p *Pool) sendTo(ctx context.Context, nodeId int, body []byte) ([]byte, error) {
+	p.mu.RLock()
+	node := p.Nodes[nodeId]
+	p.mu.RUnlock()
+
+	endpoint := node.provider.endpoint(node.Url)
+	req, err := http.NewRequest(http.MethodPost, endpoint.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(context.WithValue(ctx, upstreamKey{}, upstream{pool: p, nodeId: nodeId}))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := upstreamClient.Do(req)
+	if err != nil {
+		return nil, redactError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("Invalid response status: %d", resp.StatusCode)
+	}
+
+	return readLimited(resp.Body, p.Config.Limits.MaxResponseBytes, errResponseTooLarge)
+}
+
+// lowestHead returns the lowest head of the nodes, a block all of them know
+func (p *Pool) lowestHead(ids []int) int64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	head := p.Nodes[ids[0]].BlockNumber
+	for _, id := range ids {
+		if p.Nodes[id].BlockNumber < head {
+			head = p.Nodes[id].BlockNumber
+		}
+	}
+
+	return head
+}
+
+// pinHeadTags replaces the "latest" block parameters of the calls with the
+// block number, so nodes with different heads answer for the same block.
+// "pending" is left alone, a mined block would change its meaning. The body is
+// returned as is when it does not parse.
+func pinHeadTags(body []byte, head int64) []byte {
+	var batch []map[string]json.RawMessage
+	single := false
+	if err := json.Unmarshal(body, &batch); err != nil {
+		var call map[string]json.RawMessage
+		if err := json.Unmarshal(body, &call); err != nil {
+			return body
+		}
+		batch, single = []map[string]json.RawMessage{call}, true
+	}
+
+	block, _ := json.Marshal(fmt.Sprintf("0x%x", head))
+	for _, call := range batch {
+		var params []json.RawMessage
+		if err := json.Unmarshal(call["params"], &params); err != nil {
+			continue
+		}
+
+		for i, param := range params {
+			var tag string
+			if json.Unmarshal(param, &tag) == nil && tag == "latest" {
+				params[i] = block
+			}
+		}
+		call["params"], _ = json.Marshal(params)
+	}
+
+	var pinned []byte
+	var err error
+	if single {
+		pinned, err = json.Marshal(batch[0])
+	} else {
+		pinned, err = json.Marshal(batch)
+	}
+	if err != nil {
+		return body
+	}
+
+	return pinned
+}
+
+// serveQuorum sends the request to Quorum.Nodes nodes concurrently and returns
+// the response a majority of them agrees on, or a JSON-RPC error otherwise
+func (p *Pool) serveQuorum(w http.ResponseWriter, r *http.Request, body []byte, methods []string) {
+	k := p.Config.Quorum.Nodes
+	majority := k/2 + 1
+
+	method := methods[0]
+	if len(methods) > 1 {
+		method = "batch"
+	}
+
+	ids := p.healthyNodes(k)
+	if len(ids) < majority {
+		p.metrics.quorumRequests.Inc(p.Config.Name, method, "unavailable")
+		writeRPCError(w, http.StatusOK, body, rpcErrorServer, fmt.Sprintf("Quorum unavailable: %d of %d nodes healthy", len(ids), k))
+		return
+	}
+
+	// nodes within block_threshold of each other would disagree on the head
+	pinned := pinHeadTags(body, p.lowestHead(ids))
+
+	// numbers the upstream spans of the nodes asked
+	ctx := withAttemptCounter(r.Context())
+
+	replies := make([]quorumReply, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, id int) {
+			defer wg.Done()
+
+			reply := &replies[i]
+			if reply.body, reply.err = p.sendTo(ctx, id, pinned); reply.err == nil {
+				reply.results, reply.err = decodeResults(reply.body, "")
+			}
+			if reply.err != nil {
+				Warning.Printf("Quorum read of %s failed with: %v", method, reply.err)
+			}
+		}(i, id)
+	}
+	wg.Wait()
+
+	best, votes := -1, 0
+	for i := range replies {
+		if replies[i].err != nil {
+			continue
+		}
+
+		count := 0
+		for j := range replies {
+			if replies[j].err == nil && reflect.DeepEqual(replies[i].results, replies[j].results) {
+				count++
+			}
+		}
+
+		if count > votes {
+			best, votes = i, count
+		}
+	}
+
+	w.Header().Set("X-Quorum", fmt.Sprintf("%d/%d", votes, k))
+
+	if votes < majority {
+		p.metrics.quorumRequests.Inc(p.Config.Name, method, "disagreed")
+		Warning.Printf("Quorum not reached for %s: %d of %d nodes agree", method, votes, k)
+		writeRPCError(w, http.StatusOK, body, rpcErrorServer, fmt.Sprintf("Quorum not reached: %d of %d nodes agree", votes, k))
+		return
+	}
+
+	p.metrics.quorumRequests.Inc(p.Config.Name, method, "agreed")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(replies[best].body)
+}
@@ -0,0 +1,98 @@
+package balancer
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const getNonce = `{"jsonrpc":"2.0","id":1,"method":"eth_getTransactionCount","params":["0x0","latest"]}`
+
+func newQuorumPool(t *testing.T, nodes []*fakeNode) *Pool {
+	return newTestPool(t, nodes, func(c *PoolConfig) {
+		c.BlockThreshold = 5
+		c.Quorum = QuorumConfig{Nodes: len(nodes), Methods: []string{"eth_getTransactionCount", "eth_getBalance"}}
+	})
+}
+
+func TestQuorumPinsLatest(t *testing.T) {
+	a, b, c := newFakeNode(100), newFakeNode(101), newFakeNode(102)
+	defer a.Close()
+	defer b.Close()
+	defer c.Close()
+
+	pool := newQuorumPool(t, []*fakeNode{a, b, c})
+	observeTest(pool)
+
+	proxy := newTestProxy(pool)
+	defer proxy.Close()
+
+	resp, err := http.Post(proxy.URL, "application/json", strings.NewReader(getNonce))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if votes := resp.Header.Get("X-Quorum"); votes != "3/3" {
+		t.Errorf("X-Quorum = %q for nodes within block_threshold, want 3/3", votes)
+	}
+}
+
+func TestQuorumDisagreement(t *testing.T) {
+	a, b, c := newFakeNode(100), newFakeNode(100), newFakeNode(100)
+	defer a.Close()
+	defer b.Close()
+	defer c.Close()
+
+	pool := newQuorumPool(t, []*fakeNode{a, b, c})
+	observeTest(pool)
+
+	proxy := newTestProxy(pool)
+	defer proxy.Close()
+
+	// a failing minority is outvoted
+	a.setRPCError(&JSONRPCError{Code: -32000, Message: "failed"})
+	_, reply := postRPC(t, proxy.URL, getNonce)
+	if reply.Error != nil {
+		t.Fatalf("error %v with one failing node of 3", reply.Error)
+	}
+
+	// every node answers eth_getBalance with its own URL
+	_, reply = postRPC(t, proxy.URL, getBalance)
+	if reply.Error == nil || !strings.Contains(reply.Error.Message, "Quorum not reached") {
+		t.Errorf("error = %v, want quorum not reached", reply.Error)
+	}
+}
+
+func TestPinHeadTags(t *testing.T) {
+	pinned := string(pinHeadTags([]byte(`[`+getBalance+`,{"jsonrpc":"2.0","id":2,"method":"eth_call","params":[{},"pending"]}]`), 100))
+	if strings.Contains(pinned, "latest") || !strings.Contains(pinned, `"pending"`) || strings.Count(pinned, `"0x64"`) != 1 {
+		t.Errorf("pinned body %s, want latest at 0x64 and pending left alone", pinned)
+	}
+
+	if body := string(pinHeadTags([]byte("not json"), 100)); body != "not json" {
+		t.Errorf("pinned invalid body %s, want it unchanged", body)
+	}
+}
+
+func TestQuorumInheritedByPools(t *testing.T) {
+	config := Config{PoolConfig: PoolConfig{
+		Interval: 1,
+		Nodes:    []NodeConfig{{Url: "http://a"}, {Url: "http://b"}, {Url: "http://c"}},
+		Quorum:   QuorumConfig{Nodes: 3, Methods: []string{"eth_getBalance"}},
+	}}
+	config.Pools = []PoolConfig{
+		{Name: "pair", PathPrefix: "/pair", Nodes: []NodeConfig{{Url: "http://d"}, {Url: "http://e"}}},
+		{Name: "single", PathPrefix: "/single", Nodes: []NodeConfig{{Url: "http://f"}}},
+	}
+
+	if err := config.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if q := config.Pools[0].Quorum; q.Nodes != 2 {
+		t.Errorf("quorum of the pair = %+v, want 2 nodes", q)
+	}
+	if q := config.Pools[1].Quorum; q.Nodes != 0 {
+		t.Errorf("quorum of the single node = %+v, want none", q)
+	}
+}
@@ -0,0 +1,182 @@
+package balancer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"unicode"
+)
+
+const redacted = "xxxxx"
+
+// secretParams are substrings of query parameter names holding credentials
+var secretParams = []string{"key", "token", "secret", "pass", "auth"}
+
+// publicSegments are path segments of node URLs known not to be credentials
+var publicSegments = map[string]bool{
+	"api": true, "bc": true, "eth": true, "ethereum": true, "ext": true, "http": true, "jsonrpc": true,
+	"mainnet": true, "rpc": true, "ws": true,
+}
+
+// isPublicSegment reports whether a path segment is a version like v3, a known
+// word like rpc or already redacted. Any other segment may be an API key or
+// project id, e.g. the Infura project id in https://mainnet.infura.io/v3/<id>.
+func isPublicSegment(segment string) bool {
+	if segment == "" || publicSegments[strings.ToLower(segment)] || strings.HasSuffix(segment, redacted) {
+		return true
+	}
+
+	if len(segment) < 2 || (segment[0] != 'v' && segment[0] != 'V') {
+		return false
+	}
+	for _, r := range segment[1:] {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// fingerprint is a short hash of a credential, telling apart nodes and
+// clients which only differ by their credentials without revealing any part
+// of them
+func fingerprint(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:4])
+}
+
+// redactURL returns the URL with the user info, path segments which are not
+// known to be public and credential query parameters replaced, safe for logs,
+// metrics and APIs. A redacted URL ends with the fingerprint of the original
+// one as its fragment, so URLs differing by their credentials only stay apart.
+func redactURL(u url.URL) string {
+	original := u.String()
+	changed := false
+
+	if u.User != nil && u.User.String() != redacted {
+		u.User = url.User(redacted)
+		changed = true
+	}
+
+	segments := strings.Split(u.Path, "/")
+	for i, segment := range segments {
+		if !isPublicSegment(segment) {
+			segments[i] = redacted
+			changed = true
+		}
+	}
+	u.Path = strings.Join(segments, "/")
+	u.RawPath = ""
+
+	if u.RawQuery != "" {
+		query := u.Query()
+		for name := range query {
+			for _, secret := range secretParams {
+				if strings.Contains(strings.ToLower(name), secret) {
+					changed = changed || query.Get(name) != redacted
+					query.Set(name, redacted)
+					break
+				}
+			}
+		}
+		u.RawQuery = query.Encode()
+	}
+
+	if changed {
+		u.Fragment = fingerprint(original)
+	}
+
+	return u.String()
+}
+
+// redactURLString redacts a configured URL, entirely if it does not parse
+func redactURLString(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return redacted
+	}
+
+	return redactURL(*u)
+}
+
+// redactError removes credentials from the URL net/http puts into the errors
+// of failed requests
+func redactError(err error) error {
+	if urlError, ok := err.(*url.Error); ok {
+		return &url.Error{Op: urlError.Op, URL: redactURLString(urlError.URL), Err: urlError.Err}
+	}
+
+	return err
+}
+
+// String identifies the node by its redacted URL in logs, metric labels,
+// events and the history
+func (n Node) String() string {
+	if n.name != "" {
+		return n.name
+	}
+
+	// the fingerprint tells apart projects of the same provider
+	if n.provider != nil {
+		return redactURL(n.provider.withKey(n.Url, n.provider.apiKey))
+	}
+
+	return redactURL(n.Url)
+}
+
+// MarshalJSON shows the redacted URL in /info
+func (n Node) MarshalJSON() ([]byte, error) {
+	type plain Node
+	return json.Marshal(struct {
+		plain
+		Url string
+	}{plain(n), n.String()})
+}
+
+// Redacted returns a copy of the config safe to log
+func (c Config) Redacted() Config {
+	c.PoolConfig = c.PoolConfig.Redacted()
+
+	pools := make([]PoolConfig, len(c.Pools))
+	for i, p := range c.Pools {
+		pools[i] = p.Redacted()
+	}
+	c.Pools = pools
+
+	if c.Tracing.Endpoint != "" {
+		c.Tracing.Endpoint = redactURLString(c.Tracing.Endpoint)
+	}
+	headers := make(map[string]string, len(c.Tracing.Headers))
+	for k := range c.Tracing.Headers {
+		headers[k] = redacted
+	}
+	c.Tracing.Headers = headers
+
+	clients := make(map[string]string, len(c.Usage.Clients))
+	for key, name := range c.Usage.Clients {
+		clients[redacted+"#"+fingerprint(key)] = name
+	}
+	c.Usage.Clients = clients
+
+	return c
+}
+
+func (p PoolConfig) Redacted() PoolConfig {
+	nodes := make([]NodeConfig, len(p.Nodes))
+	for i, n := range p.Nodes {
+		nodes[i] = NodeConfig{Url: redactURLString(n.Url), Tier: n.Tier, Type: n.Type, RateLimit: n.RateLimit}
+		if n.ApiKey != "" {
+			nodes[i].ApiKey = redacted
+		}
+	}
+	p.Nodes = nodes
+
+	if p.Shadow.Url != "" {
+		p.Shadow.Url = redactURLString(p.Shadow.Url)
+	}
+
+	return p
+}
@@ -0,0 +1,231 @@
+package balancer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+type JSONRPCRequest struct {
+	Version string        `json:"jsonrpc"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+	Id      int           `json:"id"`
+}
+
+type JSONRPCResponse struct {
+	Version string          `json:"jsonrpc"`
+	Id      int             `json:"id"`
+	Result  json.RawMessage `json:"result"`
+	Error   *JSONRPCError   `json:"error,omitempty"`
+}
+
+type JSONRPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *JSONRPCError) Error() string {
+	return fmt.Sprintf("JSON-RPC error %d: %s", e.Code, e.Message)
+}
+
+// Block holds the fields of eth_getBlockByNumber the observer relies on
+type Block struct {
+	Number     int64
+	Hash       string
+	ParentHash string
+	Timestamp  int64
+}
+
+type rpcBlock struct {
+	Number     string `json:"number"`
+	Hash       string `json:"hash"`
+	ParentHash string `json:"parentHash"`
+	Timestamp  string `json:"timestamp"`
+}
+
+type rpcMethodCall struct {
+	Method string `json:"method"`
+}
+
+// peekBody reads the request body of at most limit bytes, zero meaning no
+// limit, and replaces it with a copy, leaving it intact for the proxy
+func peekBody(r *http.Request, limit int64) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+
+	body, err := readLimited(r.Body, limit, errRequestTooLarge)
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	return body, err
+}
+
+// parseRPCMethods returns the JSON-RPC methods called by a single or batch
+// request
+func parseRPCMethods(body []byte) []string {
+	var batch []rpcMethodCall
+	if err := json.Unmarshal(body, &batch); err != nil {
+		var call rpcMethodCall
+		if err := json.Unmarshal(body, &call); err != nil {
+			return nil
+		}
+		batch = append(batch, call)
+	}
+
+	methods := make([]string, len(batch))
+	for i, call := range batch {
+		methods[i] = call.Method
+	}
+
+	return methods
+}
+
+type rpcRequestId struct {
+	Id json.RawMessage `json:"id"`
+}
+
+// writeRPCError answers every call of a single or batch request with a
+// JSON-RPC error carrying the call's id
+func writeRPCError(w http.ResponseWriter, status int, body []byte, code int, message string) {
+	rpcError := &JSONRPCError{Code: code, Message: message}
+
+	response := func(id json.RawMessage) interface{} {
+		if len(id) == 0 {
+			id = json.RawMessage("null")
+		}
+		return map[string]interface{}{"jsonrpc": "2.0", "id": id, "error": rpcError}
+	}
+
+	var payload interface{}
+	var batch []rpcRequestId
+	if err := json.Unmarshal(body, &batch); err == nil && len(batch) > 0 {
+		responses := make([]interface{}, len(batch))
+		for i, call := range batch {
+			responses[i] = response(call.Id)
+		}
+		payload = responses
+	} else {
+		var call rpcRequestId
+		json.Unmarshal(body, &call)
+		payload = response(call.Id)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(payload)
+}
+
+// callNode performs a single JSON-RPC call against the node and decodes its
+// result into result
+func callNode(ctx context.Context, node *Node, method string, params []interface{}, result interface{}) error {
+	if params == nil {
+		params = make([]interface{}, 0)
+	}
+
+	request := JSONRPCRequest{
+		Version: "2.0",
+		Method:  method,
+		Id:      node.RPCCounter,
+		Params:  params,
+	}
+
+	body := new(bytes.Buffer)
+	err := json.NewEncoder(body).Encode(request)
+	if err != nil {
+		return err
+	}
+
+	endpoint := node.provider.endpoint(node.Url)
+	req, err := http.NewRequest(http.MethodPost, endpoint.String(), body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	node.provider.authorize(req)
+	injectTraceParent(ctx, req.Header)
+
+	if err := node.provider.wait(ctx, node.String(), 0); err != nil {
+		return err
+	}
+
+	resp, err := node.client.Do(req)
+	if err != nil {
+		if urlError, ok := err.(*url.Error); ok {
+			// the endpoint may contain the API key
+			return &url.Error{Op: urlError.Op, URL: node.String(), Err: urlError.Err}
+		}
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if err := rateLimitedResponse(resp); err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 {
+		return errors.Errorf("Invalid response status: %d", resp.StatusCode)
+	}
+	node.RPCCounter += 1
+
+	var response JSONRPCResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return err
+	}
+
+	if response.Error != nil {
+		if err := node.provider.rateLimitedCall(response.Error); err != nil {
+			return err
+		}
+		return response.Error
+	}
+
+	return json.Unmarshal(response.Result, result)
+}
+
+func getChainId(ctx context.Context, node *Node) (int64, error) {
+	var result string
+	if err := callNode(ctx, node, "eth_chainId", nil, &result); err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(result, 0, 64)
+}
+
+// getBlock fetches the header fields of the block with the given tag, e.g.
+// "latest", without its transactions
+func getBlock(ctx context.Context, node *Node, tag string) (Block, error) {
+	var result *rpcBlock
+	if err := callNode(ctx, node, "eth_getBlockByNumber", []interface{}{tag, false}, &result); err != nil {
+		return Block{}, err
+	}
+
+	if result == nil {
+		return Block{}, errors.Errorf("Block %s not found", tag)
+	}
+
+	number, err := strconv.ParseInt(result.Number, 0, 64)
+	if err != nil {
+		return Block{}, errors.Wrap(err, "Invalid block number")
+	}
+
+	timestamp, err := strconv.ParseInt(result.Timestamp, 0, 64)
+	if err != nil {
+		return Block{}, errors.Wrap(err, "Invalid block timestamp")
+	}
+
+	return Block{
+		Number:     number,
+		Hash:       result.Hash,
+		ParentHash: result.ParentHash,
+		Timestamp:  timestamp,
+	}, nil
+}
@@ -0,0 +1,96 @@
+package balancer
+
+import (
+	"reflect"
+	"strings"
+)
+
+// ConfigSchema describes the config file as a JSON Schema derived from the
+// yaml tags of Config, for editors and linting config changes
+func ConfigSchema() map[string]interface{} {
+	schema := typeSchema(reflect.TypeOf(Config{}))
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = "LoadBalancer config"
+
+	return schema
+}
+
+func typeSchema(t reflect.Type) map[string]interface{} {
+	// nodes are either a plain URL or a mapping, see NodeConfig.UnmarshalYAML
+	if t == reflect.TypeOf(NodeConfig{}) {
+		return map[string]interface{}{
+			"oneOf": []interface{}{
+				map[string]interface{}{"type": "string"},
+				structSchema(t),
+			},
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		return structSchema(t)
+	}
+
+	return map[string]interface{}{}
+}
+
+func structSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	addProperties(t, properties)
+
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+// yamlKey returns the yaml key of a struct field, or "" when the field is not
+// decoded, and whether it is inlined into its parent
+func yamlKey(field reflect.StructField) (string, bool) {
+	if field.PkgPath != "" {
+		return "", false
+	}
+
+	tag := strings.Split(field.Tag.Get("yaml"), ",")
+	if tag[0] == "-" {
+		return "", false
+	}
+
+	if len(tag) > 1 && tag[1] == "inline" {
+		return "", true
+	}
+
+	if tag[0] == "" {
+		return strings.ToLower(field.Name), false
+	}
+
+	return tag[0], false
+}
+
+// addProperties adds the fields of a struct by yaml key, flattening inlined
+// structs like yaml does
+func addProperties(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		name, inline := yamlKey(field)
+		if inline {
+			addProperties(field.Type, properties)
+		} else if name != "" {
+			properties[name] = typeSchema(field.Type)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package balancer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	// responses larger than this are not compared
+	shadowMaxBody = 10 << 20
+
+	shadowLogLimit = 256
+)
+
+// readOnlyMethods are mirrored to the shadow node unless the pool lists its own
+var readOnlyMethods = []string{
+	"eth_blockNumber",
+	"eth_call",
+	"eth_chainId",
+	"eth_estimateGas",
+	"eth_gasPrice",
+	"eth_getBalance",
+	"eth_getBlockByHash",
+	"eth_getBlockByNumber",
+	"eth_getBlockTransactionCountByHash",
+	"eth_getBlockTransactionCountByNumber",
+	"eth_getCode",
+	"eth_getLogs",
+	"eth_getStorageAt",
+	"eth_getTransactionByHash",
+	"eth_getTransactionCount",
+	"eth_getTransactionReceipt",
+	"net_version",
+}
+
+// Shadow mirrors a share of the read-only requests of a pool to a node that
+// is not serving clients and compares its responses with the primary ones
+type Shadow struct {
+	pool      string
+	url       string
+	ratio     float64
+	methods   map[string]bool
+	client    *http.Client
+	transport *http.Transport
+	metrics   *metrics
+}
+
+func newShadow(config PoolConfig, m *metrics) *Shadow {
+	if config.Shadow.Url == "" {
+		return nil
+	}
+
+	methods := config.Shadow.Methods
+	if len(methods) == 0 {
+		methods = readOnlyMethods
+	}
+
+	transport := newTransport(config)
+	s := &Shadow{
+		pool:    config.Name,
+		url:     config.Shadow.Url,
+		ratio:   config.Shadow.Percentage / 100,
+		methods: make(map[string]bool),
+		metrics: m,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   time.Duration(config.ConnectionTimeout) * time.Second,
+		},
+		transport: transport,
+	}
+	for _, m := range methods {
+		s.methods[m] = true
+	}
+
+	return s
+}
+
+// sample decides whether a request calling the given methods is mirrored
+func (s *Shadow) sample(methods []string) bool {
+	if s == nil || len(methods) == 0 {
+		return false
+	}
+
+	for _, m := range methods {
+		if !s.methods[m] {
+			return false
+		}
+	}
+
+	return rand.Float64() < s.ratio
+}
+
+// closeIdleConnections closes the idle connections to the shadow node
+func (s *Shadow) closeIdleConnections() {
+	if s != nil {
+		s.transport.CloseIdleConnections()
+	}
+}
+
+// bodyRecorder keeps a copy of the response written to the client
+type bodyRecorder struct {
+	*statusRecorder
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (r *bodyRecorder) Write(b []byte) (int, error) {
+	if !r.overflow && r.body.Len()+len(b) <= shadowMaxBody {
+		r.body.Write(b)
+	} else {
+		r.overflow = true
+	}
+
+	return r.statusRecorder.Write(b)
+}
+
+// compare sends the request to the shadow node and compares the responses by
+// JSON-RPC id. It is meant to run in its own goroutine started together with
+// the primary request, so that both nodes see the same head; the primary
+// response is read once served is closed.
+func (s *Shadow) compare(request []byte, methods []string, primary *bodyRecorder, served <-chan struct{}) {
+	method := methods[0]
+	if len(methods) > 1 {
+		method = "batch"
+	}
+
+	var body []byte
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(request))
+	if err == nil {
+		body, err = ioutil.ReadAll(io.LimitReader(resp.Body, shadowMaxBody))
+		resp.Body.Close()
+		if err == nil && resp.StatusCode != http.StatusOK {
+			err = errors.Errorf("Invalid response status: %d", resp.StatusCode)
+		}
+	}
+
+	<-served
+	if primary.overflow || primary.status != http.StatusOK {
+		return
+	}
+	s.metrics.shadowRequests.Inc(s.pool, method)
+
+	primaryResults, decodeErr := decodeResults(primary.body.Bytes(), primary.Header().Get("Content-Encoding"))
+	if decodeErr != nil {
+		Warning.Printf("Unable to decode primary response of %s for shadow comparison: %v", method, decodeErr)
+		return
+	}
+
+	var shadowResults map[string]interface{}
+	if err == nil {
+		shadowResults, err = decodeResults(body, resp.Header.Get("Content-Encoding"))
+	}
+	if err != nil {
+		err = redactError(err)
+		Warning.Printf("Shadow node failed %s with: %v", method, err)
+		s.metrics.shadowErrors.Inc(s.pool, method)
+		return
+	}
+
+	if !reflect.DeepEqual(primaryResults, shadowResults) {
+		s.metrics.shadowMismatches.Inc(s.pool, method)
+		Warning.Printf("Shadow response of %s differs, primary: %s shadow: %s",
+			method, truncate(primary.body.String(), shadowLogLimit), truncate(string(body), shadowLogLimit))
+	}
+}
+
+// decodeResults maps the ids of a single or batch JSON-RPC response to their
+// result or error
+func decodeResults(body []byte, encoding string) (map[string]interface{}, error) {
+	if strings.EqualFold(encoding, "gzip") {
+		reader, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		if body, err = ioutil.ReadAll(reader); err != nil {
+			return nil, err
+		}
+	}
+
+	type response struct {
+		Id     json.RawMessage `json:"id"`
+		Result interface{}     `json:"result"`
+		Error  interface{}     `json:"error"`
+	}
+
+	var batch []response
+	if err := json.Unmarshal(body, &batch); err != nil {
+		var single response
+		if err := json.Unmarshal(body, &single); err != nil {
+			return nil, err
+		}
+		batch = append(batch, single)
+	}
+
+	results := make(map[string]interface{}, len(batch))
+	for _, r := range batch {
+		if r.Error != nil {
+			results[string(r.Id)] = map[string]interface{}{"error": r.Error}
+		} else {
+			results[string(r.Id)] = r.Result
+		}
+	}
+
+	return results, nil
+}
+
+func truncate(s string, limit int) string {
+	if len(s) <= limit {
+		return s
+	}
+
+	return s[:limit] + "..."
+}
@@ -0,0 +1,54 @@
+package balancer
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// metricValue reads a single value of the metric family
+func metricValue(m *MetricVec, labelValues ...string) float64 {
+	key := m.key(labelValues)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.values[key]
+}
+
+func TestShadowSentWithPrimary(t *testing.T) {
+	a, s := newFakeNode(100), newFakeNode(100)
+	defer a.Close()
+	defer s.Close()
+
+	pool := newTestPool(t, []*fakeNode{a}, func(c *PoolConfig) {
+		c.Name = "shadowed"
+		c.Shadow = ShadowConfig{Url: s.server.URL, Percentage: 100}
+	})
+	observeTest(pool)
+
+	proxy := newTestProxy(pool)
+	defer proxy.Close()
+
+	a.setLatency(300 * time.Millisecond)
+	if status, _ := postRPC(t, proxy.URL, `{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`); status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	// a block arriving after the primary response must not reach the comparison
+	s.setBlock(101)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for metricValue(pool.metrics.shadowRequests, "shadowed", "eth_blockNumber") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("shadow response not compared")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if calls := s.calls("eth_blockNumber"); calls != 1 {
+		t.Errorf("shadow eth_blockNumber calls = %d, want 1", calls)
+	}
+	if mismatches := metricValue(pool.metrics.shadowMismatches, "shadowed", "eth_blockNumber"); mismatches != 0 {
+		t.Errorf("%v mismatches, want none for nodes at the same head", mismatches)
+	}
+}
@@ -0,0 +1,88 @@
+package balancer
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// headTime is the timestamp of the newest head of the available nodes, zero
+// when no node is available
+func headTime(nodes []Node) (newest int64) {
+	for _, n := range nodes {
+		if n.Available && !n.Forked && n.BlockTime > newest {
+			newest = n.BlockTime
+		}
+	}
+
+	return newest
+}
+
+// checkStaleness flags the pool as stale when even its newest head is older
+// than max_head_age. Every node may be in sync while the network has stopped
+// producing blocks. Must be called with the pool lock held.
+func (p *Pool) checkStaleness() {
+	newest := headTime(p.Nodes)
+	if newest == 0 {
+		return
+	}
+
+	age := time.Since(time.Unix(newest, 0))
+	p.metrics.headAge.Set(age.Seconds(), p.Config.Name)
+
+	maxAge := time.Duration(p.Config.MaxHeadAge) * time.Second
+	stale := maxAge > 0 && age > maxAge
+
+	if stale && !p.Stale {
+		Error.Printf("Pool %s is stale, its head is %v old", p.Config.Name, age.Round(time.Second))
+		p.events.Publish(Event{Type: EventPoolStale, Pool: p.Config.Name, Block: headBlock(p.Nodes)})
+	} else if !stale && p.Stale {
+		Info.Printf("Pool %s produces blocks again", p.Config.Name)
+		p.events.Publish(Event{Type: EventPoolResumed, Pool: p.Config.Name, Block: headBlock(p.Nodes)})
+	}
+
+	p.Stale = stale
+	if stale {
+		p.metrics.poolStale.Set(1, p.Config.Name)
+	} else {
+		p.metrics.poolStale.Set(0, p.Config.Name)
+	}
+}
+
+type poolReadiness struct {
+	Ready   bool   `json:"ready"`
+	Problem string `json:"problem,omitempty"`
+}
+
+func (p *Pool) readiness() poolReadiness {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	switch {
+	case p.CurrentNodeId < 0:
+		return poolReadiness{Problem: "no available nodes"}
+	case p.Stale:
+		return poolReadiness{Problem: "head is older than max_head_age"}
+	}
+
+	return poolReadiness{Ready: true}
+}
+
+// readyHandler answers 200 when every pool has a current node with a fresh
+// head and 503 otherwise, for load balancer and Kubernetes readiness probes
+func readyHandler(pools []*Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		readiness := make(map[string]poolReadiness)
+		for _, p := range pools {
+			readiness[p.Config.Name] = p.readiness()
+			if !readiness[p.Config.Name].Ready {
+				status = http.StatusServiceUnavailable
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(readiness)
+	}
+}
@@ -0,0 +1,80 @@
+package balancer
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func getReady(t *testing.T, url string) (int, map[string]poolReadiness) {
+	resp, err := http.Get(url + "/ready")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var readiness map[string]poolReadiness
+	if err := json.NewDecoder(resp.Body).Decode(&readiness); err != nil {
+		t.Fatal(err)
+	}
+
+	return resp.StatusCode, readiness
+}
+
+func TestObserveStaleness(t *testing.T) {
+	a, b := newFakeNode(100), newFakeNode(100)
+	defer a.Close()
+	defer b.Close()
+
+	pool := newTestPool(t, []*fakeNode{a, b}, func(c *PoolConfig) { c.MaxHeadAge = 60 })
+	proxy := newTestProxy(pool)
+	defer proxy.Close()
+
+	if status, _ := getReady(t, proxy.URL); status != http.StatusServiceUnavailable {
+		t.Errorf("status before observing = %d, want 503", status)
+	}
+
+	observeTest(pool)
+	if pool.Stale {
+		t.Error("pool with a fresh head is stale")
+	}
+	if status, readiness := getReady(t, proxy.URL); status != http.StatusOK || !readiness[defaultPoolName].Ready {
+		t.Errorf("status %d, readiness %+v, want ready", status, readiness)
+	}
+
+	// the network stalled, all nodes agree on an old head
+	stalled := time.Now().Add(-2 * time.Minute)
+	a.setHeadTime(stalled)
+	b.setHeadTime(stalled)
+	if current := observeTest(pool); current < 0 {
+		t.Fatal("no current node in a stalled network")
+	}
+	if !pool.Stale {
+		t.Error("pool with a head older than max_head_age is not stale")
+	}
+	if status, readiness := getReady(t, proxy.URL); status != http.StatusServiceUnavailable || readiness[defaultPoolName].Ready {
+		t.Errorf("status %d, readiness %+v, want not ready", status, readiness)
+	}
+
+	// a single node with a fresh head is enough
+	b.setHeadTime(time.Now())
+	b.setBlock(101)
+	observeTest(pool)
+	if pool.Stale {
+		t.Error("pool is still stale after a new block")
+	}
+}
+
+func TestObserveStalenessDisabled(t *testing.T) {
+	a := newFakeNode(100)
+	defer a.Close()
+
+	a.setHeadTime(time.Now().Add(-time.Hour))
+	pool := newTestPool(t, []*fakeNode{a}, nil)
+
+	observeTest(pool)
+	if pool.Stale {
+		t.Error("pool is stale without max_head_age")
+	}
+}
@@ -0,0 +1,424 @@
+package balancer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OTLP span kinds and status codes, see opentelemetry-proto trace.proto
+const (
+	SpanKindInternal = 1
+	SpanKindServer   = 2
+	SpanKindClient   = 3
+
+	statusCodeOk    = 1
+	statusCodeError = 2
+
+	traceBatchSize     = 512
+	traceQueueSize     = 4096
+	traceFlushInterval = 5 * time.Second
+)
+
+type spanContextKey struct{}
+
+type attemptCounterKey struct{}
+
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+}
+
+type Span struct {
+	SpanContext
+	ParentID   [8]byte
+	Name       string
+	Kind       int
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{}
+	Err        error
+
+	mu     sync.Mutex
+	tracer *Tracer
+}
+
+// Tracer batches finished spans and exports them to an OTLP/HTTP collector
+// using the JSON encoding
+type Tracer struct {
+	endpoint    string
+	serviceName string
+	headers     map[string]string
+	client      *http.Client
+	queue       chan *Span
+	// done stops the exporter, which closes stopped once it flushed
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// NewTracer starts exporting spans to the configured collector, it returns nil
+// when tracing is disabled. Close stops it.
+func NewTracer(config TracingConfig) *Tracer {
+	if config.Endpoint == "" {
+		return nil
+	}
+
+	serviceName := config.ServiceName
+	if serviceName == "" {
+		serviceName = "loadbalancer"
+	}
+
+	t := &Tracer{
+		endpoint:    strings.TrimSuffix(config.Endpoint, "/") + "/v1/traces",
+		serviceName: serviceName,
+		headers:     config.Headers,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		queue:       make(chan *Span, traceQueueSize),
+		done:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+
+	go t.run()
+
+	Info.Printf("Exporting traces to %s", redactURLString(t.endpoint))
+
+	return t
+}
+
+// Close exports the spans still queued and stops the exporter. Spans finished
+// afterwards are dropped.
+func (t *Tracer) Close() {
+	if t == nil {
+		return
+	}
+
+	close(t.done)
+	<-t.stopped
+}
+
+// StartSpan starts a span as a child of the span or remote span context stored
+// in ctx. When tracing is disabled, i.e. on a nil Tracer, it returns a nil
+// span, all Span methods are safe to call on nil.
+func (t *Tracer) StartSpan(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		Attributes: make(map[string]interface{}),
+		tracer:     t,
+	}
+
+	if parent, ok := ctx.Value(spanContextKey{}).(SpanContext); ok {
+		span.TraceID = parent.TraceID
+		span.ParentID = parent.SpanID
+	} else {
+		rand.Read(span.TraceID[:])
+	}
+	rand.Read(span.SpanID[:])
+
+	return context.WithValue(ctx, spanContextKey{}, span.SpanContext), span
+}
+
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.Attributes[key] = value
+	s.mu.Unlock()
+}
+
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.Err = err
+	s.mu.Unlock()
+}
+
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.End = time.Now()
+	s.mu.Unlock()
+
+	select {
+	case <-s.tracer.done:
+		// the tracer is closed
+	case s.tracer.queue <- s:
+	default:
+		Warning.Printf("Trace queue is full, dropping span %q", s.Name)
+	}
+}
+
+// extractTraceParent reads a W3C traceparent header so that spans created for
+// the request join the caller's trace
+func extractTraceParent(ctx context.Context, header http.Header) context.Context {
+	parts := strings.Split(header.Get("traceparent"), "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return ctx
+	}
+
+	var sc SpanContext
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return ctx
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+func injectTraceParent(ctx context.Context, header http.Header) {
+	sc, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	if !ok {
+		return
+	}
+
+	header.Set("traceparent", "00-"+hex.EncodeToString(sc.TraceID[:])+"-"+hex.EncodeToString(sc.SpanID[:])+"-01")
+}
+
+// tracingTransport records a client span for every upstream round trip with
+// the tracer of the pool it is routed to, so repeated attempts for the same
+// incoming request show up as sibling spans
+type tracingTransport struct {
+	base http.RoundTripper
+}
+
+func withAttemptCounter(ctx context.Context) context.Context {
+	return context.WithValue(ctx, attemptCounterKey{}, new(int32))
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var tracer *Tracer
+	if target, ok := req.Context().Value(upstreamKey{}).(upstream); ok {
+		tracer = target.pool.tracer
+	}
+
+	ctx, span := tracer.StartSpan(req.Context(), "upstream "+req.Method, SpanKindClient)
+	if span == nil {
+		return t.base.RoundTrip(req)
+	}
+	defer span.Finish()
+
+	if counter, ok := ctx.Value(attemptCounterKey{}).(*int32); ok {
+		span.SetAttribute("lb.attempt", int(atomic.AddInt32(counter, 1)))
+	}
+	span.SetAttribute("server.address", req.URL.Host)
+	span.SetAttribute("http.request.method", req.Method)
+
+	req = req.WithContext(ctx)
+	injectTraceParent(ctx, req.Header)
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		span.SetError(err)
+		return resp, err
+	}
+
+	span.SetAttribute("http.response.status_code", resp.StatusCode)
+	if resp.StatusCode >= 500 {
+		span.SetError(errors.Errorf("Invalid response status: %d", resp.StatusCode))
+	}
+
+	return resp, nil
+}
+
+func (t *Tracer) run() {
+	ticker := time.NewTicker(traceFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, traceBatchSize)
+
+	for {
+		select {
+		case span := <-t.queue:
+			batch = append(batch, span)
+			if len(batch) < traceBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		case <-t.done:
+			t.flush(append(batch, t.drain()...))
+			close(t.stopped)
+			return
+		}
+
+		t.flush(batch)
+		batch = batch[:0]
+	}
+}
+
+// drain returns the queued spans without waiting for more
+func (t *Tracer) drain() []*Span {
+	var spans []*Span
+	for {
+		select {
+		case span := <-t.queue:
+			spans = append(spans, span)
+		default:
+			return spans
+		}
+	}
+}
+
+func (t *Tracer) flush(batch []*Span) {
+	if len(batch) == 0 {
+		return
+	}
+
+	if err := t.export(batch); err != nil {
+		Error.Printf("Exporting %d spans failed with: %v", len(batch), err)
+	}
+}
+
+type otlpValue map[string]interface{}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+func toOTLPValue(value interface{}) otlpValue {
+	switch v := value.(type) {
+	case string:
+		return otlpValue{"stringValue": v}
+	case bool:
+		return otlpValue{"boolValue": v}
+	case int:
+		return otlpValue{"intValue": strconv.FormatInt(int64(v), 10)}
+	case int64:
+		return otlpValue{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		return otlpValue{"doubleValue": v}
+	case []string:
+		values := make([]otlpValue, len(v))
+		for i, s := range v {
+			values[i] = otlpValue{"stringValue": s}
+		}
+		return otlpValue{"arrayValue": map[string]interface{}{"values": values}}
+	default:
+		return otlpValue{"stringValue": fmt.Sprint(v)}
+	}
+}
+
+func (s *Span) toOTLP() otlpSpan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	span := otlpSpan{
+		TraceID:           hex.EncodeToString(s.TraceID[:]),
+		SpanID:            hex.EncodeToString(s.SpanID[:]),
+		Name:              s.Name,
+		Kind:              s.Kind,
+		StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+		Status:            otlpStatus{Code: statusCodeOk},
+	}
+
+	if s.ParentID != [8]byte{} {
+		span.ParentSpanID = hex.EncodeToString(s.ParentID[:])
+	}
+
+	for k, v := range s.Attributes {
+		span.Attributes = append(span.Attributes, otlpAttribute{Key: k, Value: toOTLPValue(v)})
+	}
+
+	if s.Err != nil {
+		span.Status = otlpStatus{Code: statusCodeError, Message: s.Err.Error()}
+	}
+
+	return span
+}
+
+func (t *Tracer) export(batch []*Span) error {
+	spans := make([]otlpSpan, len(batch))
+	for i, s := range batch {
+		spans[i] = s.toOTLP()
+	}
+
+	payload := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []otlpAttribute{
+						{Key: "service.name", Value: toOTLPValue(t.serviceName)},
+					},
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "github.com/OnGridSystems/LoadBalancer"},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	}
+
+	body := new(bytes.Buffer)
+	if err := json.NewEncoder(body).Encode(payload); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, t.endpoint, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return redactError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("Invalid response status: %d", resp.StatusCode)
+	}
+
+	return nil
+}
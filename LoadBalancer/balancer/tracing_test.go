@@ -0,0 +1,232 @@
+package balancer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// collector is an OTLP/HTTP collector stand-in keeping the spans it receives
+type collector struct {
+	server *httptest.Server
+
+	mu      sync.Mutex
+	spans   []otlpSpan
+	headers http.Header
+}
+
+func newCollector() *collector {
+	c := &collector{}
+	c.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []otlpSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if r.URL.Path != "/v1/traces" || json.NewDecoder(r.Body).Decode(&payload) != nil {
+			http.Error(w, "Invalid export", http.StatusBadRequest)
+			return
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.headers = r.Header
+		for _, rs := range payload.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				c.spans = append(c.spans, ss.Spans...)
+			}
+		}
+	}))
+
+	return c
+}
+
+// named returns the received spans with the name
+func (c *collector) named(name string) []otlpSpan {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var spans []otlpSpan
+	for _, s := range c.spans {
+		if s.Name == name {
+			spans = append(spans, s)
+		}
+	}
+
+	return spans
+}
+
+func (s otlpSpan) attribute(key string) otlpValue {
+	for _, a := range s.Attributes {
+		if a.Key == key {
+			return a.Value
+		}
+	}
+
+	return nil
+}
+
+func TestTracing(t *testing.T) {
+	c := newCollector()
+	defer c.server.Close()
+
+	a := newFakeNode(100)
+	defer a.Close()
+
+	lb, err := New(Config{PoolConfig: PoolConfig{Interval: 60, Nodes: []NodeConfig{{Url: a.server.URL}}},
+		Tracing: TracingConfig{Endpoint: c.server.URL, Headers: map[string]string{"X-Token": "secret"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(lb)
+	defer server.Close()
+
+	traceId, parentId := strings.Repeat("ab", 16), strings.Repeat("cd", 8)
+	req, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(getBalance))
+	req.Header.Set("traceparent", "00-"+traceId+"-"+parentId+"-01")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// flushes the queued spans
+	lb.Close()
+
+	proxy := c.named("proxy POST")
+	if len(proxy) != 1 {
+		t.Fatalf("%d server spans, want 1", len(proxy))
+	}
+	if proxy[0].TraceID != traceId || proxy[0].ParentSpanID != parentId || proxy[0].Kind != SpanKindServer {
+		t.Errorf("server span %+v, want a child of the traceparent", proxy[0])
+	}
+
+	upstream := c.named("upstream POST")
+	if len(upstream) != 1 {
+		t.Fatalf("%d client spans, want 1", len(upstream))
+	}
+	if upstream[0].TraceID != traceId || upstream[0].ParentSpanID != proxy[0].SpanID || upstream[0].Kind != SpanKindClient {
+		t.Errorf("client span %+v, want a child of the server span", upstream[0])
+	}
+	if attempt := upstream[0].attribute("lb.attempt"); attempt["intValue"] != "1" {
+		t.Errorf("lb.attempt = %v, want 1", attempt)
+	}
+
+	observe, probes := c.named("observe"), c.named("probe eth_getBlockByNumber")
+	if len(observe) != 1 || len(probes) != 1 {
+		t.Fatalf("%d observe and %d probe spans, want 1 each", len(observe), len(probes))
+	}
+	if probes[0].TraceID != observe[0].TraceID || probes[0].ParentSpanID != observe[0].SpanID {
+		t.Errorf("probe span %+v, want a child of the observe span", probes[0])
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.headers.Get("X-Token") != "secret" {
+		t.Errorf("export headers %v, want the configured X-Token", c.headers)
+	}
+}
+
+func TestTracingTransport(t *testing.T) {
+	tracer := &Tracer{queue: make(chan *Span, 2), done: make(chan struct{})}
+	pool := &Pool{shared: &shared{tracer: tracer}}
+
+	var mu sync.Mutex
+	var traceparents []string
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		traceparents = append(traceparents, r.Header.Get("traceparent"))
+		mu.Unlock()
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer node.Close()
+
+	traceId, parentId := strings.Repeat("ab", 16), strings.Repeat("cd", 8)
+	header := http.Header{"Traceparent": []string{"00-" + traceId + "-" + parentId + "-01"}}
+	ctx := withAttemptCounter(extractTraceParent(context.Background(), header))
+	ctx = context.WithValue(ctx, upstreamKey{}, upstream{pool: pool})
+
+	transport := &tracingTransport{base: http.DefaultTransport}
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest(http.MethodPost, node.URL, nil)
+		resp, err := transport.RoundTrip(req.WithContext(ctx))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	for i, want := range []string{"1", "2"} {
+		span := (<-tracer.queue).toOTLP()
+		if span.TraceID != traceId || span.ParentSpanID != parentId || span.Kind != SpanKindClient {
+			t.Errorf("client span %+v, want a child of the traceparent", span)
+		}
+		if attempt := span.attribute("lb.attempt"); attempt["intValue"] != want {
+			t.Errorf("lb.attempt = %v, want %s", attempt, want)
+		}
+		if span.Status.Code != statusCodeError {
+			t.Errorf("status %+v of a 502 response, want an error", span.Status)
+		}
+
+		mu.Lock()
+		if traceparent := traceparents[i]; traceparent != "00-"+traceId+"-"+span.SpanID+"-01" {
+			t.Errorf("upstream traceparent %q, want the client span %s", traceparent, span.SpanID)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestTracerExport(t *testing.T) {
+	c := newCollector()
+	defer c.server.Close()
+
+	exporter := &Tracer{
+		endpoint:    c.server.URL + "/v1/traces",
+		serviceName: "loadbalancer",
+		headers:     map[string]string{"X-Token": "secret"},
+		client:      http.DefaultClient,
+	}
+
+	span := &Span{Name: "observe", Kind: SpanKindInternal, Attributes: map[string]interface{}{"lb.nodes": 2}}
+	span.SetError(errors.New("No available nodes"))
+	if err := exporter.export([]*Span{span}); err != nil {
+		t.Fatal(err)
+	}
+
+	observe := c.named("observe")
+	if len(observe) != 1 {
+		t.Fatalf("%d observe spans, want 1", len(observe))
+	}
+	if nodes := observe[0].attribute("lb.nodes"); nodes["intValue"] != "2" {
+		t.Errorf("lb.nodes = %v, want 2", nodes)
+	}
+	if status := observe[0].Status; status.Code != statusCodeError || status.Message != "No available nodes" {
+		t.Errorf("status %+v, want the error", status)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.headers.Get("X-Token") != "secret" {
+		t.Errorf("export headers %v, want the configured X-Token", c.headers)
+	}
+}
+
+func TestTracingDisabled(t *testing.T) {
+	var tracer *Tracer
+
+	ctx, span := tracer.StartSpan(context.Background(), "observe", SpanKindInternal)
+	if ctx != context.Background() || span != nil {
+		t.Error("span started without a tracer")
+	}
+	span.SetAttribute("key", "value")
+	span.Finish()
+	tracer.Close()
+}
@@ -0,0 +1,44 @@
+package balancer
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultMaxIdleConns    = 100
+	defaultIdleConnTimeout = 90
+	defaultKeepAlive       = 30
+)
+
+// newTransport returns the connection pool shared by the probes and proxied
+// requests of a node. HTTP/2 is negotiated with nodes serving TLS unless
+// disabled.
+func newTransport(config PoolConfig) *http.Transport {
+	c := config.Transport
+
+	dialer := &net.Dialer{
+		Timeout:   time.Duration(c.DialTimeout) * time.Second,
+		KeepAlive: time.Duration(c.KeepAlive) * time.Second,
+	}
+
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          c.MaxIdleConns,
+		MaxIdleConnsPerHost:   c.MaxIdleConns,
+		MaxConnsPerHost:       c.MaxConns,
+		IdleConnTimeout:       time.Duration(c.IdleConnTimeout) * time.Second,
+		TLSHandshakeTimeout:   time.Duration(config.ConnectionTimeout) * time.Second,
+		ExpectContinueTimeout: time.Second,
+		ForceAttemptHTTP2:     !c.DisableHTTP2,
+	}
+
+	if c.DisableHTTP2 {
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+
+	return transport
+}
@@ -0,0 +1,119 @@
+package balancer
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const benchmarkBurst = 16
+
+// newBenchmarkPool returns a pool of one TLS node, like the hosted providers,
+// taking a millisecond per request and a counter of the connections opened to
+// it
+func newBenchmarkPool(b *testing.B) (*Pool, *int64, func()) {
+	conns := new(int64)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x10"}`))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(conns, 1)
+		}
+	}
+	server.StartTLS()
+
+	config := PoolConfig{Name: defaultPoolName, Interval: 30, Nodes: []NodeConfig{{Url: server.URL}}}
+	if err := config.applyDefaults(); err != nil {
+		b.Fatal(err)
+	}
+
+	pool, err := NewPool(config)
+	if err != nil {
+		b.Fatal(err)
+	}
+	pool.Nodes[0].transport.TLSClientConfig = server.Client().Transport.(*http.Transport).TLSClientConfig
+
+	return pool, conns, server.Close
+}
+
+// benchmarkSendTo sends bursts of concurrent requests, between which all
+// connections to the node are idle
+func benchmarkSendTo(b *testing.B, pool *Pool, conns *int64) {
+	body := []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var wg sync.WaitGroup
+		for j := 0; j < benchmarkBurst; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				if _, err := pool.sendTo(context.Background(), 0, body); err != nil {
+					b.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+
+	b.ReportMetric(float64(atomic.LoadInt64(conns))/float64(b.N), "conns/op")
+}
+
+// BenchmarkSendToDefaultTransport is the baseline, http.DefaultTransport keeps
+// only two idle connections per host and dials new ones for every burst
+func BenchmarkSendToDefaultTransport(b *testing.B) {
+	pool, conns, stop := newBenchmarkPool(b)
+	defer stop()
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = pool.Nodes[0].transport.TLSClientConfig
+	defer transport.CloseIdleConnections()
+	pool.Nodes[0].transport = transport
+
+	benchmarkSendTo(b, pool, conns)
+}
+
+func BenchmarkSendToNodeTransport(b *testing.B) {
+	pool, conns, stop := newBenchmarkPool(b)
+	defer stop()
+	defer pool.Nodes[0].transport.CloseIdleConnections()
+
+	benchmarkSendTo(b, pool, conns)
+}
+
+func BenchmarkProbe(b *testing.B) {
+	pool, conns, stop := newBenchmarkPool(b)
+	defer stop()
+	defer pool.Nodes[0].transport.CloseIdleConnections()
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var wg sync.WaitGroup
+		for j := 0; j < benchmarkBurst; j++ {
+			wg.Add(1)
+			go func(node Node) {
+				defer wg.Done()
+
+				var result string
+				if err := callNode(context.Background(), &node, "eth_blockNumber", nil, &result); err != nil {
+					b.Error(err)
+				}
+			}(pool.Nodes[0])
+		}
+		wg.Wait()
+	}
+
+	b.ReportMetric(float64(atomic.LoadInt64(conns))/float64(b.N), "conns/op")
+}
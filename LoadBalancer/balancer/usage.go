@@ -0,0 +1,355 @@
+package balancer
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultUsageHeader    = "X-Api-Key"
+	defaultUsageInterval  = 3600
+	defaultUsageRetention = 7 * 24
+	defaultUsageWindow    = 24 * time.Hour
+
+	// unnamed clients counted on their own per interval
+	defaultUsageMaxClients = 1000
+
+	// label of the methods and clients not counted on their own in /metrics
+	otherLabel = "other"
+)
+
+// standardMethods are counted on their own, along with the methods listed in
+// usage.weights. Methods are taken from the requests, so others would let
+// clients grow the store and the series in /metrics without bound.
+var standardMethods = map[string]bool{
+	"eth_accounts": true, "eth_blobBaseFee": true, "eth_blockNumber": true, "eth_call": true, "eth_chainId": true,
+	"eth_createAccessList": true, "eth_estimateGas": true, "eth_feeHistory": true, "eth_gasPrice": true,
+	"eth_getBalance": true, "eth_getBlockByHash": true, "eth_getBlockByNumber": true, "eth_getBlockReceipts": true,
+	"eth_getBlockTransactionCountByHash": true, "eth_getBlockTransactionCountByNumber": true, "eth_getCode": true,
+	"eth_getFilterChanges": true, "eth_getFilterLogs": true, "eth_getLogs": true, "eth_getProof": true,
+	"eth_getStorageAt": true, "eth_getTransactionByBlockHashAndIndex": true,
+	"eth_getTransactionByBlockNumberAndIndex": true, "eth_getTransactionByHash": true, "eth_getTransactionCount": true,
+	"eth_getTransactionReceipt": true, "eth_getUncleCountByBlockHash": true, "eth_getUncleCountByBlockNumber": true,
+	"eth_maxPriorityFeePerGas": true, "eth_newBlockFilter": true, "eth_newFilter": true,
+	"eth_newPendingTransactionFilter": true, "eth_sendRawTransaction": true, "eth_sendTransaction": true,
+	"eth_sign": true, "eth_signTransaction": true, "eth_syncing": true, "eth_uninstallFilter": true,
+	"net_listening": true, "net_peerCount": true, "net_version": true, "web3_clientVersion": true, "web3_sha3": true,
+}
+
+type usageKey struct {
+	pool   string
+	client string
+	method string
+}
+
+type usageCounter struct {
+	requests int64
+	units    float64
+}
+
+// UsageStore counts the calls and compute units of every client per method in
+// intervals of usage.interval, kept in memory for usage.retention_hours
+type UsageStore struct {
+	config UsageConfig
+	// named are the client names of usage.clients
+	named   map[string]bool
+	metrics *metrics
+
+	mu        sync.Mutex
+	intervals map[int64]map[usageKey]*usageCounter
+	// clients are the unnamed clients counted on their own per interval
+	clients map[int64]map[string]bool
+}
+
+// NewUsageStore returns an empty store exposing the usage to metrics of its own
+func NewUsageStore(config UsageConfig) *UsageStore {
+	return newUsageStore(config, newMetrics())
+}
+
+func newUsageStore(config UsageConfig, m *metrics) *UsageStore {
+	named := make(map[string]bool)
+	for _, name := range config.Clients {
+		named[name] = true
+	}
+
+	return &UsageStore{
+		config:    config,
+		named:     named,
+		metrics:   m,
+		intervals: make(map[int64]map[usageKey]*usageCounter),
+		clients:   make(map[int64]map[string]bool),
+	}
+}
+
+// client identifies the sender of a request by its API key header, named if
+// listed in usage.clients, or else by its IP address
+func (u *UsageStore) client(r *http.Request) string {
+	if key := r.Header.Get(u.config.Header); key != "" {
+		if name, ok := u.config.Clients[key]; ok {
+			return name
+		}
+		// unknown keys are credentials too
+		return "key:" + redacted + "#" + fingerprint(key)
+	}
+
+	if u.config.TrustForwardedFor {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+
+	return r.RemoteAddr
+}
+
+// method folds the methods which are neither standard nor weighted into other
+func (u *UsageStore) method(method string) string {
+	if _, weighted := u.config.Weights[method]; method != "" && !weighted && !standardMethods[method] {
+		return otherLabel
+	}
+
+	return method
+}
+
+// metricLabels bounds the series in /metrics to the named clients, the store
+// also keeps up to usage.max_clients unnamed ones apart
+func (u *UsageStore) metricLabels(client string, method string) (string, string) {
+	if !u.named[client] {
+		client = otherLabel
+	}
+
+	return client, u.method(method)
+}
+
+// storedClient folds the unnamed clients beyond usage.max_clients of the
+// interval into other. Must be called with the lock held.
+func (u *UsageStore) storedClient(start int64, client string) string {
+	if u.named[client] {
+		return client
+	}
+
+	clients, ok := u.clients[start]
+	if !ok {
+		clients = make(map[string]bool)
+		u.clients[start] = clients
+	}
+	if !clients[client] {
+		if len(clients) >= u.config.MaxClients {
+			return otherLabel
+		}
+		clients[client] = true
+	}
+
+	return client
+}
+
+func (u *UsageStore) weight(method string) float64 {
+	if weight, ok := u.config.Weights[method]; ok {
+		return weight
+	}
+
+	return u.config.DefaultWeight
+}
+
+// Record accounts the calls of a request routed to the pool
+func (u *UsageStore) Record(pool string, r *http.Request, methods []string) {
+	client := u.client(r)
+	interval := int64(u.config.Interval)
+	start := time.Now().Unix() / interval * interval
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	counters, ok := u.intervals[start]
+	if !ok {
+		counters = make(map[usageKey]*usageCounter)
+		u.intervals[start] = counters
+		u.expire(start)
+	}
+
+	if len(methods) == 0 {
+		// not JSON-RPC, counted but free
+		methods = []string{""}
+	}
+	client = u.storedClient(start, client)
+
+	var units float64
+	for _, method := range methods {
+		key := usageKey{pool: pool, client: client, method: u.method(method)}
+		counter, ok := counters[key]
+		if !ok {
+			counter = &usageCounter{}
+			counters[key] = counter
+		}
+
+		weight := 0.0
+		if method != "" {
+			weight = u.weight(method)
+		}
+		counter.requests++
+		counter.units += weight
+		units += weight
+
+		metricClient, metricMethod := u.metricLabels(client, method)
+		u.metrics.clientRequests.Inc(pool, metricClient, metricMethod)
+	}
+	metricClient, _ := u.metricLabels(client, "")
+	u.metrics.clientUnits.Add(units, pool, metricClient)
+}
+
+// expire drops the intervals past the retention. Must be called with the
+// lock held.
+func (u *UsageStore) expire(now int64) {
+	cutoff := now - int64(u.config.RetentionHours)*3600
+	for start := range u.intervals {
+		if start < cutoff {
+			delete(u.intervals, start)
+			delete(u.clients, start)
+		}
+	}
+}
+
+// UsageRow is the usage of a client calling a method on a pool during the
+// interval starting at Time
+type UsageRow struct {
+	Time     time.Time `json:"time"`
+	Pool     string    `json:"pool"`
+	Client   string    `json:"client"`
+	Method   string    `json:"method"`
+	Requests int64     `json:"requests"`
+	Units    float64   `json:"units"`
+}
+
+// Query returns the usage of the intervals since from, optionally only of a
+// pool and client, ordered by time, pool, client and method
+func (u *UsageStore) Query(from time.Time, pool string, client string) []UsageRow {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var rows []UsageRow
+	for start, counters := range u.intervals {
+		if start+int64(u.config.Interval) <= from.Unix() {
+			continue
+		}
+
+		for key, counter := range counters {
+			if (pool != "" && key.pool != pool) || (client != "" && key.client != client) {
+				continue
+			}
+
+			rows = append(rows, UsageRow{
+				Time:     time.Unix(start, 0).UTC(),
+				Pool:     key.pool,
+				Client:   key.client,
+				Method:   key.method,
+				Requests: counter.requests,
+				Units:    counter.units,
+			})
+		}
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if !a.Time.Equal(b.Time) {
+			return a.Time.Before(b.Time)
+		}
+		if a.Pool != b.Pool {
+			return a.Pool < b.Pool
+		}
+		if a.Client != b.Client {
+			return a.Client < b.Client
+		}
+		return a.Method < b.Method
+	})
+
+	return rows
+}
+
+type clientUsage struct {
+	Pool     string  `json:"pool"`
+	Client   string  `json:"client"`
+	Requests int64   `json:"requests"`
+	Units    float64 `json:"units"`
+}
+
+// usageHandler reports the usage over the window (a Go duration, 24h by
+// default) as JSON, with the totals per client, or as CSV with format=csv
+func usageHandler(usage *UsageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if usage == nil {
+			http.Error(w, "Usage accounting is not enabled", http.StatusNotFound)
+			return
+		}
+
+		window := defaultUsageWindow
+		if param := r.URL.Query().Get("window"); param != "" {
+			var err error
+			if window, err = time.ParseDuration(param); err != nil || window <= 0 {
+				http.Error(w, "Invalid window: "+param, http.StatusBadRequest)
+				return
+			}
+		}
+
+		now := time.Now()
+		rows := usage.Query(now.Add(-window), r.URL.Query().Get("pool"), r.URL.Query().Get("client"))
+
+		if r.URL.Query().Get("format") == "csv" {
+			w.Header().Set("Content-Type", "text/csv")
+			w.Header().Set("Content-Disposition", `attachment; filename="usage.csv"`)
+
+			out := csv.NewWriter(w)
+			out.Write([]string{"time", "pool", "client", "method", "requests", "units"})
+			for _, row := range rows {
+				out.Write([]string{
+					row.Time.Format(time.RFC3339),
+					row.Pool,
+					row.Client,
+					row.Method,
+					strconv.FormatInt(row.Requests, 10),
+					strconv.FormatFloat(row.Units, 'f', -1, 64),
+				})
+			}
+			out.Flush()
+			return
+		}
+
+		var clients []*clientUsage
+		byClient := make(map[[2]string]*clientUsage)
+		for _, row := range rows {
+			key := [2]string{row.Pool, row.Client}
+			c, ok := byClient[key]
+			if !ok {
+				c = &clientUsage{Pool: row.Pool, Client: row.Client}
+				byClient[key] = c
+				clients = append(clients, c)
+			}
+			c.Requests += row.Requests
+			c.Units += row.Units
+		}
+		sort.Slice(clients, func(i, j int) bool { return clients[i].Units > clients[j].Units })
+
+		js, err := json.MarshalIndent(map[string]interface{}{
+			"from":    now.Add(-window),
+			"to":      now,
+			"clients": clients,
+			"usage":   rows,
+		}, "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(js)
+	}
+}
@@ -0,0 +1,178 @@
+package balancer
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUsage(t *testing.T) {
+	a := newFakeNode(100)
+	defer a.Close()
+
+	config := UsageConfig{
+		Enabled: true,
+		Clients: map[string]string{"racecourse-key": "racecourse"},
+		Weights: map[string]float64{"eth_getBalance": 10},
+	}
+	if err := config.applyDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	usage := NewUsageStore(config)
+
+	pool := newTestPool(t, []*fakeNode{a}, nil)
+	pool.usage = usage
+	observeTest(pool)
+
+	proxy := newTestProxy(pool)
+	defer proxy.Close()
+
+	send := func(key string, body string) {
+		req, _ := http.NewRequest("POST", proxy.URL, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("X-Api-Key", key)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	send("racecourse-key", getBalance)
+	send("racecourse-key", `[`+getBalance+`,{"jsonrpc":"2.0","id":2,"method":"eth_chainId","params":[]}]`)
+	send("unknown-secret-key", getBalance)
+	send("", `{"jsonrpc":"2.0","id":2,"method":"eth_chainId","params":[]}`)
+
+	rows := usage.Query(time.Now().Add(-time.Hour), "", "")
+	got := make(map[string]UsageRow)
+	for _, row := range rows {
+		got[row.Client+" "+row.Method] = row
+	}
+
+	for key, want := range map[string][2]float64{
+		"racecourse eth_getBalance": {2, 20},
+		"racecourse eth_chainId":    {1, 1},
+		"key:xxxxx#" + fingerprint("unknown-secret-key") + " eth_getBalance": {1, 10},
+		"127.0.0.1 eth_chainId": {1, 1},
+	} {
+		if row := got[key]; float64(row.Requests) != want[0] || row.Units != want[1] {
+			t.Errorf("%s: %d requests, %v units, want %v", key, row.Requests, row.Units, want)
+		}
+	}
+	if len(rows) != 4 {
+		t.Errorf("%d usage rows, want 4: %+v", len(rows), rows)
+	}
+
+	resp, err := http.Get(proxy.URL + "/usage?client=racecourse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report struct {
+		Clients []clientUsage `json:"clients"`
+	}
+	json.NewDecoder(resp.Body).Decode(&report)
+	resp.Body.Close()
+	if len(report.Clients) != 1 || report.Clients[0].Requests != 3 || report.Clients[0].Units != 21 {
+		t.Errorf("clients %+v, want racecourse with 3 requests and 21 units", report.Clients)
+	}
+
+	resp, err = http.Get(proxy.URL + "/usage?format=csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(resp.Body).ReadAll()
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 5 || strings.Join(records[0], ",") != "time,pool,client,method,requests,units" {
+		t.Errorf("CSV export %v, want a header and 4 rows", records)
+	}
+}
+
+func TestUsageExpires(t *testing.T) {
+	config := UsageConfig{RetentionHours: 1}
+	config.applyDefaults()
+	store := NewUsageStore(config)
+
+	old := time.Now().Add(-2*time.Hour).Unix() / 3600 * 3600
+	store.intervals[old] = map[usageKey]*usageCounter{{pool: "default", client: "a", method: "eth_call"}: {requests: 1}}
+
+	req, _ := http.NewRequest("POST", "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	store.Record("default", req, []string{"eth_call"})
+
+	if _, ok := store.intervals[old]; ok {
+		t.Error("interval past the retention kept")
+	}
+	if rows := store.Query(time.Unix(0, 0), "", "10.0.0.1"); len(rows) != 1 {
+		t.Errorf("usage of 10.0.0.1: %+v, want one row", rows)
+	}
+}
+
+func TestUsageMetricLabels(t *testing.T) {
+	config := UsageConfig{
+		Clients: map[string]string{"racecourse-key": "racecourse"},
+		Weights: map[string]float64{"trace_block": 50},
+	}
+	config.applyDefaults()
+	store := NewUsageStore(config)
+
+	for _, c := range []struct {
+		client, method, wantClient, wantMethod string
+	}{
+		{"racecourse", "eth_getBalance", "racecourse", "eth_getBalance"},
+		{"racecourse", "trace_block", "racecourse", "trace_block"},
+		{"racecourse", "made_up_method", "racecourse", otherLabel},
+		{"10.0.0.1", "eth_call", otherLabel, "eth_call"},
+		{"key:xxxxx#" + fingerprint("unknown-secret-key"), "", otherLabel, ""},
+	} {
+		client, method := store.metricLabels(c.client, c.method)
+		if client != c.wantClient || method != c.wantMethod {
+			t.Errorf("labels of %s %s = %s %s, want %s %s", c.client, c.method, client, method, c.wantClient, c.wantMethod)
+		}
+	}
+}
+
+func TestUsageStoreBounded(t *testing.T) {
+	config := UsageConfig{Clients: map[string]string{"racecourse-key": "racecourse"}, MaxClients: 2}
+	config.applyDefaults()
+	store := NewUsageStore(config)
+
+	for _, c := range []struct {
+		key, addr, method string
+	}{
+		{"", "10.0.0.1:1234", "eth_call"},
+		{"", "10.0.0.2:1234", "made_up_method"},
+		{"", "10.0.0.3:1234", "eth_call"},
+		{"", "10.0.0.4:1234", "another_made_up_method"},
+		{"racecourse-key", "10.0.0.5:1234", "eth_call"},
+	} {
+		req, _ := http.NewRequest("POST", "/", nil)
+		req.RemoteAddr = c.addr
+		if c.key != "" {
+			req.Header.Set("X-Api-Key", c.key)
+		}
+		store.Record("default", req, []string{c.method})
+	}
+
+	got := make(map[string]int64)
+	for _, row := range store.Query(time.Now().Add(-time.Hour), "", "") {
+		got[row.Client+" "+row.Method] = row.Requests
+	}
+	want := map[string]int64{
+		"10.0.0.1 eth_call":   1,
+		"10.0.0.2 other":      1,
+		"other eth_call":      1,
+		"other other":         1,
+		"racecourse eth_call": 1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("usage %v, want %v", got, want)
+	}
+}
@@ -1,52 +1,87 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
-	"net/url"
+	"fmt"
+	"github.com/OnGridSystems/LoadBalancer/balancer"
+	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
-type Node struct {
-	Url         url.URL
-	BlockNumber int64
-	Available   bool
-	RPCCounter  int
-}
+const shutdownTimeout = 10 * time.Second
 
-func initNodes(config Config) []Node {
-	nodes := make([]Node, len(config.Nodes))
-
-	for i, n := range config.Nodes {
-		if url, err := url.Parse(n); err == nil {
-			nodes[i] = Node{
-				Url:         *url,
-				BlockNumber: 0,
-				Available:   false,
-				RPCCounter:  0,
-			}
-		} else {
-			panic(err)
-		}
+// validateCommand loads the config like the balancer would, reporting every
+// problem, and returns the exit status. Arguments are further config files.
+func validateCommand(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	configFlags := balancer.NewConfigFlags(flags)
+	flags.Parse(args)
+
+	if _, err := configFlags.Load(os.Environ(), flags.Args()...); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
 	}
 
-	return nodes
+	fmt.Println("OK")
+	return 0
+}
+
+func startProxy(ctx context.Context, config balancer.Config, handler http.Handler) {
+	server := &http.Server{Addr: fmt.Sprintf(":%d", config.Port), Handler: handler}
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	balancer.Info.Printf("Starting proxy on port %d", config.Port)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
 }
 
 func main() {
-	InitLogger(os.Stdout, os.Stdout, os.Stderr)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(validateCommand(os.Args[2:]))
+		case "schema":
+			js, _ := json.MarshalIndent(balancer.ConfigSchema(), "", "  ")
+			fmt.Println(string(js))
+			return
+		}
+	}
 
-	configPath := flag.String("config", "config.yml", "Path to configuration file")
+	configFlags := balancer.NewConfigFlags(flag.CommandLine)
 	flag.Parse()
 
-	config := ParseConfigWPanic(*configPath)
-	Info.Printf("Config: %+v\n", config)
-
-	nodes := initNodes(config)
+	config, err := configFlags.Load(os.Environ())
+	if err != nil {
+		panic(err)
+	}
+	balancer.Info.Printf("Config: %+v\n", config.Redacted())
 
-	var currentNodeId int
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		balancer.Info.Printf("Received %v, shutting down", <-signals)
+		cancel()
+	}()
 
-	observe(config, nodes, &currentNodeId)
-	go startPeriodicObserve(config, nodes, &currentNodeId)
+	b, err := balancer.New(config)
+	if err != nil {
+		panic(err)
+	}
+	defer b.Close()
 
-	startProxy(config.Port, nodes, &currentNodeId)
+	startProxy(ctx, config, b)
 }